@@ -0,0 +1,158 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/Yendelevium/RSSAggregator/internal/sanitize"
+)
+
+// Atom is the other big feed format out there. GitHub releases, most blog engines etc all serve Atom instead of RSS
+// The shape is pretty different from RSS, there's no <channel>, the root element is <feed> and the posts are <entry>s
+// See https://www.rfc-editor.org/rfc/rfc4287 for the whole spec
+
+// This is the namespace every Atom 1.0 document declares on its root <feed> element
+// We use it to tell an Atom feed apart from some random xml document that happens to have a <feed> tag
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+// The namespace is put in the struct tags, so encoding/xml only matches elements that are actually from Atom
+type AtomFeed struct {
+	Title    AtomContent `xml:"http://www.w3.org/2005/Atom title"`
+	Subtitle string      `xml:"http://www.w3.org/2005/Atom subtitle"`
+	Links    []AtomLink  `xml:"http://www.w3.org/2005/Atom link"`
+	Entries  []AtomEntry `xml:"http://www.w3.org/2005/Atom entry"`
+	// The feed's authors are the authors of any entry that doesn't have its own
+	Authors []AtomPerson `xml:"http://www.w3.org/2005/Atom author"`
+}
+
+// An Atom entry is the same thing as an RSSItem, just with different tag names
+type AtomEntry struct {
+	ID         string         `xml:"http://www.w3.org/2005/Atom id"`
+	Title      AtomContent    `xml:"http://www.w3.org/2005/Atom title"`
+	Links      []AtomLink     `xml:"http://www.w3.org/2005/Atom link"`
+	Updated    string         `xml:"http://www.w3.org/2005/Atom updated"`
+	Published  string         `xml:"http://www.w3.org/2005/Atom published"`
+	Summary    AtomContent    `xml:"http://www.w3.org/2005/Atom summary"`
+	Content    AtomContent    `xml:"http://www.w3.org/2005/Atom content"`
+	Authors    []AtomPerson   `xml:"http://www.w3.org/2005/Atom author"`
+	Categories []AtomCategory `xml:"http://www.w3.org/2005/Atom category"`
+}
+
+// <author><name>Jane Doe</name><email>...</email></author>. Only the name is required
+type AtomPerson struct {
+	Name string `xml:"http://www.w3.org/2005/Atom name"`
+}
+
+// <category term="go" label="Go"/>. term is the actual category, label is just an optional nicer name for showing it
+// We save the term, so filtering by category matches what the feed really tagged the post with
+type AtomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// Unlike RSS, the link isn't the text of the tag, it's in the href attribute
+// An entry can have a bunch of links, and the rel attribute tells us what each one is for
+// rel="enclosure" links are attachments like podcast audio, and have a length (in bytes) too
+type AtomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// Titles, summary and content can be text, html or xhtml, which is said in the type attribute
+// For xhtml the content is actual xml elements and not text, so we grab the inner xml instead of the chardata
+type AtomContent struct {
+	Type  string `xml:"type,attr"`
+	Body  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+// text returns whatever is inside the summary/content tag
+func (c AtomContent) text() string {
+	if c.Type == "xhtml" {
+		return strings.TrimSpace(c.Inner)
+	}
+	return strings.TrimSpace(c.Body)
+}
+
+// plainText is for titles, which we store as plain text
+// With type="html" the markup is escaped inside the tag (like A &amp;lt;b&amp;gt; or Don&amp;#8217;t),
+// so we have to unescape it and take the tags out, or the title shows up with the html in it
+func (c AtomContent) plainText() string {
+	if c.Type == "html" || c.Type == "xhtml" {
+		return sanitize.Text(c.text())
+	}
+	return c.text()
+}
+
+// alternateLink finds the link that points to the actual post on the website
+// If the rel attribute is missing, the spec says it should be treated as "alternate"
+func alternateLink(links []AtomLink) string {
+	for _, link := range links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// enclosureLinks turns the rel="enclosure" links into RSSEnclosures, so they get saved like RSS ones
+func enclosureLinks(links []AtomLink) []RSSEnclosure {
+	enclosures := []RSSEnclosure{}
+	for _, link := range links {
+		if link.Rel == "enclosure" {
+			enclosures = append(enclosures, RSSEnclosure{URL: link.Href, Length: link.Length, Type: link.Type})
+		}
+	}
+	return enclosures
+}
+
+// toRSSFeed converts the Atom feed into our RSSFeed, so that the scraper doesn't need to care about which format the feed was in
+func (atomFeed AtomFeed) toRSSFeed() RSSFeed {
+	rssFeed := RSSFeed{}
+	rssFeed.Channel.Title = atomFeed.Title.plainText()
+	rssFeed.Channel.Link = alternateLink(atomFeed.Links)
+	rssFeed.Channel.Description = atomFeed.Subtitle
+
+	for _, entry := range atomFeed.Entries {
+		authors := entry.Authors
+		if len(authors) == 0 {
+			authors = atomFeed.Authors
+		}
+		creators := []string{}
+		for _, author := range authors {
+			creators = append(creators, author.Name)
+		}
+		categories := []string{}
+		for _, category := range entry.Categories {
+			categories = append(categories, category.Term)
+		}
+
+		// Prefer the summary as the description, and fallback on the full content if there is no summary
+		// The content is saved as it is too, so the full article is there even when there's a summary
+		content := entry.Content.text()
+		description := entry.Summary.text()
+		if description == "" {
+			description = content
+		}
+
+		// published is optional in Atom, but updated is always there, so we use that if we have to
+		pubDate := entry.Published
+		if pubDate == "" {
+			pubDate = entry.Updated
+		}
+
+		rssFeed.Channel.Item = append(rssFeed.Channel.Item, RSSItem{
+			Title:       entry.Title.plainText(),
+			Link:        alternateLink(entry.Links),
+			Description: description,
+			Content:     content,
+			PubDate:     pubDate,
+			// Every Atom entry has to have an id, which is its guid
+			GUID:       strings.TrimSpace(entry.ID),
+			Enclosures: enclosureLinks(entry.Links),
+			Creators:   creators,
+			Categories: categories,
+		})
+	}
+	return rssFeed
+}
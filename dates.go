@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Feeds in the wild use every date format u can think of. RSS is supposed to use RFC822 ("Mon, 02 Jan 06 15:04:05 MST"),
+// Atom and JSON Feed use RFC3339, and then there's everyone who just does whatever they want
+// Like "Tue, 3 Sep 2024 10:00:00 +0000", "2024-09-03 10:00:00", "2024/09/03", "Sept 3 2024", wrong weekdays, extra spaces etc
+// parsePubDate tries really hard to make sense of all of them
+
+// time.Parse only understands named zones if it's the local zone, otherwise it just makes up a zone with 0 offset
+// So "10:00 EST" would silently become "10:00 UTC". We swap the common ones for their numeric offsets before parsing
+var namedZoneOffsets = map[string]string{
+	"UT":   "+0000",
+	"UTC":  "+0000",
+	"GMT":  "+0000",
+	"Z":    "+0000",
+	"EST":  "-0500",
+	"EDT":  "-0400",
+	"CST":  "-0600",
+	"CDT":  "-0500",
+	"MST":  "-0700",
+	"MDT":  "-0600",
+	"PST":  "-0800",
+	"PDT":  "-0700",
+	"AKST": "-0900",
+	"AKDT": "-0800",
+	"HST":  "-1000",
+	"BST":  "+0100",
+	"IST":  "+0530",
+	"CET":  "+0100",
+	"CEST": "+0200",
+	"EET":  "+0200",
+	"EEST": "+0300",
+	"MSK":  "+0300",
+	"JST":  "+0900",
+	"KST":  "+0900",
+	"AEST": "+1000",
+	"AEDT": "+1100",
+	"NZST": "+1200",
+	"NZDT": "+1300",
+}
+
+// These are the RFC822/RFC1123 style layouts, without the weekday in front, as we strip that off first
+// "2" in a layout matches both "3" and "03", and "06" is a two digit year (Go puts 69-99 in the 1900s, the rest in the 2000s)
+var rfc822Layouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 -07:00",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 Jan 06 15:04:05",
+	"2 January 2006 15:04:05",
+	"Jan 2 2006 15:04:05 -0700",
+	"Jan 2 2006 15:04:05",
+	"January 2 2006 15:04:05",
+	"Jan 2 15:04:05 2006",
+	"2 Jan 2006",
+	"2 January 2006",
+	"Jan 2 2006",
+	"January 2 2006",
+	// RFC850, the really old one, which some servers still use ("Tuesday, 03-Sep-24 10:00:00 GMT")
+	"2-Jan-06 15:04:05 -0700",
+	"2-Jan-2006 15:04:05 -0700",
+	"2-Jan-06 15:04:05",
+	"2-Jan-2006 15:04:05",
+}
+
+// These are the ISO8601/RFC3339 style layouts. If there's no zone, time.Parse gives us back UTC which is what we want anyways
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05.999999999-0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	// Same thing with slashes, "1" and "2" take the month and day with or without a 0 in front
+	"2006/1/2 15:04:05 -0700",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006/1/2",
+}
+
+var weekdayPrefixes = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+// parsePubDate parses a date from a feed and returns it in UTC
+// If none of the layouts work, it returns an error, and the caller decides what to fallback to
+func parsePubDate(date string) (time.Time, error) {
+	// strings.Fields splits on any amount of whitespace, so this also gets rid of double spaces, tabs and newlines
+	fields := strings.Fields(date)
+	if len(fields) == 0 {
+		return time.Time{}, errors.New("empty date")
+	}
+
+	// ISO dates are just one or two fields, and don't have any names in them, so try those before we start messing with the string
+	if t, ok := parseWithLayouts(strings.Join(fields, " "), isoLayouts); ok {
+		return t, nil
+	}
+
+	// The weekday is useless for parsing (and often wrong), so we just drop it. With or without the comma
+	if isWeekday(fields[0]) {
+		fields = fields[1:]
+	}
+	for i, field := range fields {
+		// "3 Sep, 2024" or "Sep 3, 2024"
+		field = strings.TrimSuffix(field, ",")
+		// "Sept" isn't a month name Go knows
+		if strings.EqualFold(field, "sept") {
+			field = "Sep"
+		}
+		fields[i] = field
+	}
+	if len(fields) > 0 {
+		last := fields[len(fields)-1]
+		if offset, ok := namedZoneOffsets[strings.ToUpper(last)]; ok {
+			fields[len(fields)-1] = offset
+		}
+	}
+
+	if t, ok := parseWithLayouts(strings.Join(fields, " "), rfc822Layouts); ok {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", date)
+}
+
+func parseWithLayouts(date string, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, date)
+		if err != nil {
+			continue
+		}
+		// Year 1 (the zero time) and such are never real publish dates, they're just broken feeds
+		if t.Year() < 1970 {
+			return time.Time{}, false
+		}
+		return t.UTC(), true
+	}
+	return time.Time{}, false
+}
+
+func isWeekday(field string) bool {
+	field = strings.ToLower(strings.TrimSuffix(field, ","))
+	if len(field) < 3 {
+		return false
+	}
+	for _, prefix := range weekdayPrefixes {
+		if strings.HasPrefix(field, prefix) {
+			return true
+		}
+	}
+	return false
+}
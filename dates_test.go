@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePubDate(t *testing.T) {
+	tests := []struct {
+		name string
+		date string
+		want time.Time
+	}{
+		// The proper ones
+		{"rfc1123z", "Tue, 03 Sep 2024 10:00:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"rfc1123z with an offset", "Tue, 03 Sep 2024 10:00:00 +0200", time.Date(2024, 9, 3, 8, 0, 0, 0, time.UTC)},
+		{"rfc3339", "2024-09-03T10:00:00Z", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"rfc3339 with an offset", "2024-09-03T10:00:00-05:00", time.Date(2024, 9, 3, 15, 0, 0, 0, time.UTC)},
+		{"rfc3339 with fractions", "2024-09-03T10:00:00.123Z", time.Date(2024, 9, 3, 10, 0, 0, 123000000, time.UTC)},
+
+		// Named zones get their real offset, not 0
+		{"gmt", "Tue, 03 Sep 2024 10:00:00 GMT", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"ut", "Tue, 03 Sep 2024 10:00:00 UT", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"est", "Tue, 03 Sep 2024 10:00:00 EST", time.Date(2024, 9, 3, 15, 0, 0, 0, time.UTC)},
+		{"pdt", "Tue, 03 Sep 2024 10:00:00 PDT", time.Date(2024, 9, 3, 17, 0, 0, 0, time.UTC)},
+		{"cest", "Tue, 03 Sep 2024 10:00:00 CEST", time.Date(2024, 9, 3, 8, 0, 0, 0, time.UTC)},
+		{"lowercase zone", "Tue, 03 Sep 2024 10:00:00 est", time.Date(2024, 9, 3, 15, 0, 0, 0, time.UTC)},
+
+		// Two digit years
+		{"two digit year", "Tue, 03 Sep 24 10:00:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"two digit year in the 1900s", "Thu, 01 Jan 98 10:00 GMT", time.Date(1998, 1, 1, 10, 0, 0, 0, time.UTC)},
+
+		// RFC850
+		{"rfc850", "Tuesday, 03-Sep-24 10:00:00 GMT", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"rfc850 with a four digit year", "Tuesday, 03-Sep-2024 10:00:00 EST", time.Date(2024, 9, 3, 15, 0, 0, 0, time.UTC)},
+
+		// ISO without a zone is UTC
+		{"iso without a zone", "2024-09-03T10:00:00", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"iso with a space", "2024-09-03 10:00:00", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"iso without seconds", "2024-09-03T10:00", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"iso with a numeric offset and no colon", "2024-09-03T10:00:00+0530", time.Date(2024, 9, 3, 4, 30, 0, 0, time.UTC)},
+		{"just the date", "2024-09-03", time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC)},
+
+		// Slashes
+		{"slash date", "2024/09/03", time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC)},
+		{"slash date without zeros", "2024/9/3", time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC)},
+		{"slash date with a time", "2024/09/03 10:00:00", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+
+		// The malformed stuff
+		{"wrong weekday", "Mon, 03 Sep 2024 10:00:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"no weekday", "03 Sep 2024 10:00:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"weekday without a comma", "Tue 03 Sep 2024 10:00:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"single digit day", "Tue, 3 Sep 2024 10:00:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"extra spaces", "  Tue,  03   Sep 2024\t10:00:00  +0000 ", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"no seconds", "Tue, 03 Sep 2024 10:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"no zone", "Tue, 03 Sep 2024 10:00:00", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"full month name", "3 September 2024 10:00:00 +0000", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"sept", "Sept 3, 2024", time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC)},
+		{"comma after the day", "3 Sep, 2024", time.Date(2024, 9, 3, 0, 0, 0, 0, time.UTC)},
+		{"month first", "Sep 3 2024 10:00:00", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"ansi c", "Tue Sep 3 10:00:00 2024", time.Date(2024, 9, 3, 10, 0, 0, 0, time.UTC)},
+		{"colon in the offset", "Tue, 03 Sep 2024 10:00:00 +02:00", time.Date(2024, 9, 3, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePubDate(tt.date)
+			if err != nil {
+				t.Fatalf("parsePubDate(%q) failed: %v", tt.date, err)
+			}
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("parsePubDate(%q) = %v, want %v", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+// These can't be made sense of, so the scraper falls back to the fetch time
+func TestParsePubDateErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"not a date",
+		"yesterday",
+		"2024-13-45",
+		"32 Sep 2024",
+		"Tue, 03 Foo 2024 10:00:00 +0000",
+		// The zero time, which broken feeds send
+		"0001-01-01T00:00:00Z",
+		"Mon, 01 Jan 0001 00:00:00 +0000",
+	}
+
+	for _, date := range tests {
+		t.Run(date, func(t *testing.T) {
+			if got, err := parsePubDate(date); err == nil {
+				t.Errorf("parsePubDate(%q) = %v, want an error", date, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// People usually paste a blog's homepage, not its feed url. So when we fetch a url and get a web page back,
+// we look for the feed ourselves. Most sites put a tag like this in the <head> of every page:
+// <link rel="alternate" type="application/rss+xml" title="My Blog" href="/feed.xml">
+// If a page doesn't have any, we try a few paths where feeds usually live
+
+// The type attributes that mean a <link> is a feed
+var feedLinkTypes = []string{"application/rss+xml", "application/atom+xml", "application/rdf+xml", "application/feed+json"}
+
+// Where feeds usually are, if the page doesn't link to one
+var commonFeedPaths = []string{"/feed", "/rss", "/feed.xml", "/atom.xml", "/index.xml"}
+
+// htmlPageError is what urlToFeed returns when the url is a web page and not a feed
+// It has the feeds the page links to in it, so the caller can pick one
+type htmlPageError struct {
+	pageURL    *url.URL
+	candidates []FeedCandidate
+}
+
+func (e *htmlPageError) Error() string {
+	return "url is a web page, not a feed"
+}
+
+// multipleFeedsError means a page links to more than one feed, and we can't tell which one u want
+// The handlers respond with the list, so the client can choose
+type multipleFeedsError struct {
+	candidates []FeedCandidate
+}
+
+func (e *multipleFeedsError) Error() string {
+	return fmt.Sprintf("found %v feeds, pick one", len(e.candidates))
+}
+
+// isHTML checks if the Content-Type is a web page
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// discoverFeedLinks reads the <head> of a web page, and returns the feeds it links to
+// pageURL is where we got the page from, which is what relative links are relative to (unless there's a <base>)
+func discoverFeedLinks(pageURL *url.URL, r io.Reader) []FeedCandidate {
+	candidates := []FeedCandidate{}
+	seen := map[string]bool{}
+	base := pageURL
+
+	// The tokenizer goes through the page a tag at a time, so we never have to hold the whole page in memory
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			// This is the end of the page, or the read failed. Either way, we go with what we found
+			return candidates
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				// The links are in the <head>, so there's no point reading the rest
+				return candidates
+			case "base":
+				if href, err := url.Parse(attr(token, "href")); err == nil && attr(token, "href") != "" {
+					base = pageURL.ResolveReference(href)
+				}
+			case "link":
+				if !isFeedLink(token) {
+					continue
+				}
+				href, err := url.Parse(strings.TrimSpace(attr(token, "href")))
+				if err != nil || attr(token, "href") == "" {
+					continue
+				}
+				feedURL := base.ResolveReference(href).String()
+				if seen[feedURL] {
+					continue
+				}
+				seen[feedURL] = true
+				candidates = append(candidates, FeedCandidate{
+					Url:   feedURL,
+					Title: strings.TrimSpace(attr(token, "title")),
+					Type:  strings.ToLower(strings.TrimSpace(attr(token, "type"))),
+				})
+			}
+		}
+	}
+}
+
+// isFeedLink checks if a <link> tag is rel="alternate" with one of the feed types
+// rel can have a few values in it, like rel="alternate feed"
+func isFeedLink(token html.Token) bool {
+	alternate := false
+	for _, rel := range strings.Fields(strings.ToLower(attr(token, "rel"))) {
+		if rel == "alternate" {
+			alternate = true
+		}
+	}
+	if !alternate {
+		return false
+	}
+	linkType, _, err := mime.ParseMediaType(attr(token, "type"))
+	if err != nil {
+		return false
+	}
+	for _, feedType := range feedLinkTypes {
+		if linkType == feedType {
+			return true
+		}
+	}
+	return false
+}
+
+// attr gets an attribute of a tag, or "" if it doesn't have it
+func attr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// bestFeedCandidate picks the feed from the ones a page links to
+// Blogs (WordPress especially) link to a comments feed as well as the posts feed, and nobody wants the comments one
+// If there's still more than one after that, we can't tell which is better, so we return false
+func bestFeedCandidate(candidates []FeedCandidate) (FeedCandidate, bool) {
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	notComments := []FeedCandidate{}
+	for _, candidate := range candidates {
+		if !strings.Contains(strings.ToLower(candidate.Title), "comments") {
+			notComments = append(notComments, candidate)
+		}
+	}
+	if len(notComments) == 1 {
+		return notComments[0], true
+	}
+	return FeedCandidate{}, false
+}
+
+// discoverFeed finds the feed for a web page, from what urlToFeed found in the page
+// If the page doesn't link to any feeds, we try the common paths on the same site, and go with the first one that works
+// It returns the url of the feed, and the fetched feed if we had to fetch it to find it
+func (f *feedFetcher) discoverFeed(ctx context.Context, page *htmlPageError) (string, *fetchResult, error) {
+	if len(page.candidates) > 0 {
+		best, ok := bestFeedCandidate(page.candidates)
+		if !ok {
+			return "", nil, &multipleFeedsError{candidates: page.candidates}
+		}
+		return best.Url, nil, nil
+	}
+
+	for _, path := range commonFeedPaths {
+		feedURL := page.pageURL.ResolveReference(&url.URL{Path: path}).String()
+		result, err := f.urlToFeed(ctx, feedURL, "", "")
+		if err == nil {
+			return feedURL, &result, nil
+		}
+		// No point trying the rest if we're shutting down, or the request got cancelled
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+	}
+	return "", nil, fmt.Errorf("no feeds found on %v", page.pageURL)
+}
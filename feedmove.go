@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Yendelevium/RSSAggregator/internal/database"
+	"github.com/Yendelevium/RSSAggregator/internal/feedurl"
+)
+
+// moveFeed saves that feed has moved to newURL for good, and returns the feed as it is after the move, and if it got merged
+// We save newURL exactly like the redirect gave it to us, coz that's the url that doesn't get redirected
+// If no other feed has the same normalized url, we just update the feed's url
+// If another feed already has it, it's the same feed added twice, so we merge them: the follows, posts and fetch history
+// move over to the feed that already has the url, and then this feed gets deleted. The feed we return is the one that stayed
+// It all happens in a transaction, so if anything fails halfway, nothing changes
+func moveFeed(ctx context.Context, conn *sql.DB, feed database.Feed, newURL string) (database.Feed, bool, error) {
+	if newURL == feed.Url {
+		return feed, false, nil
+	}
+	// Even if this is the same as the feed's normalized url (like /feed moving to /feed/), the url still gets updated
+	normalizedURL, err := feedurl.Normalize(newURL)
+	if err != nil {
+		return feed, false, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return feed, false, err
+	}
+	// Rollback does nothing once we've committed, so this only undoes things if we return early with an error
+	defer tx.Rollback()
+	// WithTx gives us the same sqlc queries, but running inside the transaction
+	qtx := database.New(conn).WithTx(tx)
+
+	existing, err := qtx.GetFeedByNormalizedURLs(ctx, feedurl.Variants(normalizedURL))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return feed, false, err
+	}
+	// Nobody else has the url (or the only one who does is this feed, like when it moved from http to https)
+	if errors.Is(err, sql.ErrNoRows) || existing.ID == feed.ID {
+		err = qtx.UpdateFeedURL(ctx, database.UpdateFeedURLParams{ID: feed.ID, Url: newURL, NormalizedUrl: normalizedURL})
+		if err != nil {
+			return feed, false, err
+		}
+		if err = tx.Commit(); err != nil {
+			return feed, false, err
+		}
+		feed.Url = newURL
+		feed.NormalizedUrl = normalizedURL
+		return feed, false, nil
+	}
+
+	// Another feed already has the url, so we merge this one into it
+	err = qtx.MoveFeedFollows(ctx, database.MoveFeedFollowsParams{ToFeedID: existing.ID, FromFeedID: feed.ID})
+	if err != nil {
+		return feed, false, err
+	}
+	err = qtx.MovePosts(ctx, database.MovePostsParams{ToFeedID: existing.ID, FromFeedID: feed.ID})
+	if err != nil {
+		return feed, false, err
+	}
+	err = qtx.MoveFeedFetchLogs(ctx, database.MoveFeedFetchLogsParams{ToFeedID: existing.ID, FromFeedID: feed.ID})
+	if err != nil {
+		return feed, false, err
+	}
+	err = qtx.DeleteFeed(ctx, feed.ID)
+	if err != nil {
+		return feed, false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return feed, false, err
+	}
+	return existing, true, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Yendelevium/RSSAggregator/internal/netguard"
+	"github.com/andybalholm/brotli"
+)
+
+// urlToFeed used to make a brand new http.Client every time it was called, so every fetch opened a brand new connection,
+// and it sent Go's default User-Agent, which some hosts just block
+// Now there's one feedFetcher, made in main, which the scraper and the handlers share
+// It keeps connections open between fetches, tells sites who we are, and can go through a proxy
+
+// feedFetcherConfig is everything about the fetcher u can change with env variables
+type feedFetcherConfig struct {
+	// How long a whole fetch can take, from connecting till we've read the body
+	timeout time.Duration
+	// A url site owners can go to, to find out what this bot is and who to contact about it. It goes in the User-Agent
+	contactURL string
+	// If this is empty, we don't use a proxy at all. Not even HTTP_PROXY/HTTPS_PROXY, coz with a proxy the dialer
+	// only ever sees the proxy's address, so the guard can't check what we're really connecting to.
+	// We want that to be something u turn on on purpose, not something that comes along with the environment
+	proxyURL string
+	// The biggest feed we're willing to download, in bytes (after decompressing)
+	maxBodyBytes int64
+	// Stops us from fetching private addresses, see internal/netguard
+	guard *netguard.Guard
+}
+
+type feedFetcher struct {
+	client       *http.Client
+	userAgent    string
+	maxBodyBytes int64
+	guard        *netguard.Guard
+	// If we go through a proxy, the guard has to check the feed's url before every fetch, see urlToFeed
+	proxied bool
+	// Makes sure we're polite to every host, see politeness.go
+	hosts *hostLimiter
+}
+
+func newFeedFetcher(cfg feedFetcherConfig) (*feedFetcher, error) {
+	var proxy func(*http.Request) (*url.URL, error)
+	if cfg.proxyURL != "" {
+		proxyURL, err := url.Parse(cfg.proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse proxy url: %v", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	// The Transport is the thing that actually opens connections and keeps them around for the next request
+	// These are mostly the same as http.DefaultTransport, but we keep a couple of idle connections per host
+	// since we go back to the same hosts over and over
+	transport := &http.Transport{
+		Proxy: proxy,
+		// The guard checks every address we connect to, after DNS, so we never connect to anything private
+		DialContext: cfg.guard.DialContext(&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   hostMaxConcurrent,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: cfg.timeout,
+		ExpectContinueTimeout: time.Second,
+		// Go only does gzip by itself, and we want brotli too, so we ask for compression and decode it ourselves
+		DisableCompression: true,
+	}
+
+	return &feedFetcher{
+		// Timeout is the most we wait for a fetch. If you do not specify a timeout, there is no timeout by default,
+		// and one slow site could hang a worker forever. Note that this time includes:
+		// Connection time (the time needed to connect to the distant server)
+		// Time taken by redirects (if any)
+		// Time is taken to read the response body.
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.timeout,
+			// The dialer already blocks redirects to private addresses, but we check the url of every redirect too,
+			// so a redirect to something like file:// or a blocked host gets a clear error
+			// With a proxy, this is the only check redirects get
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				// Same limit as Go's default
+				if len(via) >= 10 {
+					return errors.New("stopped after 10 redirects")
+				}
+				return cfg.guard.CheckURL(req.Context(), req.URL.String())
+			},
+		},
+		userAgent:    fmt.Sprintf("RSSAggregator/1.0 (+%s)", cfg.contactURL),
+		maxBodyBytes: cfg.maxBodyBytes,
+		guard:        cfg.guard,
+		proxied:      proxy != nil,
+		hosts:        newHostLimiter(hostMaxConcurrent, hostMinDelay),
+	}, nil
+}
+
+// checkURL checks a url someone gave us is ok to fetch, before we save it
+func (f *feedFetcher) checkURL(ctx context.Context, rawURL string) error {
+	return f.guard.CheckURL(ctx, rawURL)
+}
+
+// permanentRedirectTarget returns the url we ended up at, if we got there only through permanent redirects (301 or 308)
+// If there weren't any redirects, or any of them was temporary (like a 302), it returns ""
+// resp.Request is the last request we made, and its Response is the redirect that sent us there,
+// so we walk back through them till we get to the first request
+func permanentRedirectTarget(resp *http.Response) string {
+	if resp.Request == nil || resp.Request.Response == nil {
+		return ""
+	}
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		code := req.Response.StatusCode
+		if code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+			return ""
+		}
+	}
+	return resp.Request.URL.String()
+}
+
+// acceptEncoding is the compressions we can decode, see decodeBody
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody undoes whatever compression the server used, going by the Content-Encoding header
+// The returned ReadCloser closes the body too
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{reader, resp.Body}, nil
+	case "deflate":
+		// deflate in HTTP is supposed to be zlib, but a bunch of servers send raw deflate instead
+		// So we peek at the first 2 bytes to see if there's a zlib header
+		buffered := bufio.NewReader(resp.Body)
+		if header, err := buffered.Peek(2); err == nil && isZlibHeader(header) {
+			reader, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, err
+			}
+			return readCloser{reader, resp.Body}, nil
+		}
+		return readCloser{flate.NewReader(buffered), resp.Body}, nil
+	case "br":
+		return readCloser{brotli.NewReader(resp.Body), resp.Body}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %v", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+// A zlib header is 2 bytes, the first says the compression method is deflate (8),
+// and the 2 of them together are a multiple of 31
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+// readCloser reads from the decompressor, but closes the actual response body
+type readCloser struct {
+	io.Reader
+	body io.Closer
+}
+
+func (rc readCloser) Close() error {
+	return rc.body.Close()
+}
+
+// errFeedTooLarge is what we get when the body is bigger than maxBodyBytes
+// It has its own message, so it's obvious in the feed's last_error why the feed isn't working
+type errFeedTooLarge struct {
+	limit int64
+}
+
+func (e errFeedTooLarge) Error() string {
+	return fmt.Sprintf("feed is bigger than the %v byte limit", e.limit)
+}
+
+// limitReader is like io.LimitReader, except it errors when there's more than limit bytes, instead of just stopping
+// With io.LimitReader the parser would just see the feed end early, and we'd save half a feed
+type limitReader struct {
+	r     io.Reader
+	limit int64
+	// How many bytes we've read so far
+	n int64
+	// true once the body went over the limit
+	exceeded bool
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, errFeedTooLarge{limit: l.limit}
+	}
+	// We let it read 1 byte past the limit, that's how we know there's more than limit bytes
+	if remaining := l.limit + 1 - l.n; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		l.exceeded = true
+		l.n = l.limit
+		return 0, errFeedTooLarge{limit: l.limit}
+	}
+	return n, err
+}
+
+// These are the content types feeds actually get served as
+// application/rss+xml, application/atom+xml, text/xml, application/feed+json etc all have xml or json in them
+// A lot of servers don't know what a feed is, so they send text/plain or application/octet-stream, we let those through too
+var feedContentTypes = []string{"xml", "rss", "atom", "rdf", "json", "text/plain", "application/octet-stream"}
+
+// checkFeedContentType errors if the Content-Type says it's definitely not a feed, like an image or a web page
+// No Content-Type at all is fine, we just try to parse it
+func checkFeedContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// A broken Content-Type doesn't tell us anything, so we give it the benefit of the doubt
+		return nil
+	}
+	for _, feedType := range feedContentTypes {
+		if strings.Contains(mediaType, feedType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("content type %v is not a feed", mediaType)
+}
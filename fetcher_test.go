@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Yendelevium/RSSAggregator/internal/netguard"
+)
+
+func TestFetcherProxyGuard(t *testing.T) {
+	guard, err := netguard.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// This would go around the guard if we used it
+	t.Setenv("HTTP_PROXY", "http://127.0.0.1:1")
+	t.Setenv("HTTPS_PROXY", "http://127.0.0.1:1")
+
+	tests := []struct {
+		name     string
+		proxyURL string
+		proxied  bool
+	}{
+		{"env proxies are ignored", "", false},
+		{"configured proxy", "http://127.0.0.1:1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFeedFetcher(feedFetcherConfig{
+				timeout:  time.Second,
+				proxyURL: tt.proxyURL,
+				guard:    guard,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.proxied != tt.proxied {
+				t.Errorf("proxied = %v, want %v", f.proxied, tt.proxied)
+			}
+			if !tt.proxied && f.client.Transport.(*http.Transport).Proxy != nil {
+				t.Error("the transport uses a proxy nobody configured")
+			}
+
+			// Either way, a private address never gets fetched. Without a proxy the dialer stops it,
+			// with one urlToFeed checks the url before it goes to the proxy
+			_, err = f.urlToFeed(context.Background(), "http://169.254.169.254/latest/meta-data/", "", "")
+			if !errors.Is(err, netguard.ErrBlocked) {
+				t.Errorf("urlToFeed() = %v, want ErrBlocked", err)
+			}
+		})
+	}
+}
@@ -12,3 +12,7 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/lib/pq v1.10.9
 )
+
+require github.com/andybalholm/brotli v1.1.1
+
+require golang.org/x/net v0.35.0
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Yendelevium/RSSAggregator/internal/database"
+	"github.com/Yendelevium/RSSAggregator/internal/feedurl"
+	"github.com/go-chi/chi"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // handlerCreateFeed will have the same structure like handlerCreateUser
@@ -30,6 +37,48 @@ func (apiCfg *apiConfig) handlerCreateFeed(w http.ResponseWriter, r *http.Reques
 		repsondWithError(w, 400, fmt.Sprintf("Error parsing JSON: %v", err))
 		return
 	}
+	// The same feed can be written a bunch of ways, so we normalize the url to spot duplicates (see internal/feedurl)
+	// We still fetch the url the way u gave it to us though, coz some sites care. WordPress redirects /feed to /feed/
+	params.URL = strings.TrimSpace(params.URL)
+	normalizedURL, err := feedurl.Normalize(params.URL)
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Invalid feed url: %v", err))
+		return
+	}
+	// If someone already added this feed, we just give u that one, and u can follow it
+	// That's a 200 and not a 201, coz we didn't create anything
+	if apiCfg.respondWithExistingFeed(w, r, normalizedURL) {
+		return
+	}
+
+	// Before saving anything, we fetch the url and make sure it's really a feed
+	// Otherwise we'd only find out it's broken from the scraper's logs
+	preview, code, err := apiCfg.previewFeed(r.Context(), params.URL)
+	if err != nil {
+		respondWithPreviewError(w, code, err)
+		return
+	}
+	// If u gave us a web page, this is the feed we found on it, so we have to check that one isn't already in the db too
+	if preview.Url != params.URL {
+		params.URL = preview.Url
+		normalizedURL, err = feedurl.Normalize(params.URL)
+		if err != nil {
+			repsondWithError(w, 400, fmt.Sprintf("Invalid feed url: %v", err))
+			return
+		}
+		if apiCfg.respondWithExistingFeed(w, r, normalizedURL) {
+			return
+		}
+	}
+	// If u don't give the feed a name, it gets the feed's own title
+	if params.Name == "" {
+		params.Name = preview.Title
+	}
+	if params.Name == "" {
+		repsondWithError(w, 400, "Feed has no title, so u have to give it a name")
+		return
+	}
+
 	feed, err := apiCfg.DB.CreateFeed(r.Context(), database.CreateFeedParams{
 		ID:        uuid.New(),
 		CreatedAt: time.Now().UTC(),
@@ -37,7 +86,15 @@ func (apiCfg *apiConfig) handlerCreateFeed(w http.ResponseWriter, r *http.Reques
 		Name:      params.Name,
 		Url:       params.URL,
 		UserID:    user.ID,
+		// Only for spotting duplicates, the scraper fetches Url
+		NormalizedUrl: normalizedURL,
 	})
+	// If someone else added the same feed while we were previewing it, the unique index on normalized_url stops us
+	// from saving it twice (23505 is postgres' unique violation), and we give u their feed like we would've up there
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && apiCfg.respondWithExistingFeed(w, r, normalizedURL) {
+		return
+	}
 	if err != nil {
 		repsondWithError(w, 400, fmt.Sprintf("Couldn't create feed: %v", err))
 		return
@@ -46,6 +103,105 @@ func (apiCfg *apiConfig) handlerCreateFeed(w http.ResponseWriter, r *http.Reques
 	respondWithJSON(w, 201, databaseFeedtoFeed(feed))
 }
 
+// respondWithExistingFeed looks for a feed with this normalized url, as http or https
+// If there is one, it responds with it and returns true. It also returns true if it responded with an error
+// It returns false if there's no such feed, and the caller should go ahead and create it
+func (apiCfg *apiConfig) respondWithExistingFeed(w http.ResponseWriter, r *http.Request, normalizedURL string) bool {
+	existing, err := apiCfg.DB.GetFeedByNormalizedURLs(r.Context(), feedurl.Variants(normalizedURL))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't check for existing feeds: %v", err))
+		return true
+	}
+	respondWithJSON(w, 200, databaseFeedtoFeed(existing))
+	return true
+}
+
+// handlerPreviewFeed fetches a feed url and tells u what's there, without saving anything
+// It's authenticated like creating a feed, so randoms can't use our server to fetch stuff
+func (apiCfg *apiConfig) handlerPreviewFeed(w http.ResponseWriter, r *http.Request, user database.User) {
+	type parameters struct {
+		URL string `json:"url"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Error parsing JSON: %v", err))
+		return
+	}
+
+	preview, code, err := apiCfg.previewFeed(r.Context(), params.URL)
+	if err != nil {
+		respondWithPreviewError(w, code, err)
+		return
+	}
+	respondWithJSON(w, 200, preview)
+}
+
+// previewFeed checks the url is allowed, fetches it, and makes sure it parses as a feed
+// If the url is a web page, we find the feed it links to, and preview that instead. The preview's url is the feed's url
+// If it doesn't work out, it returns the status code to respond with, along with the error
+func (apiCfg *apiConfig) previewFeed(ctx context.Context, url string) (FeedPreview, int, error) {
+	if url == "" {
+		return FeedPreview{}, 400, errors.New("url is required")
+	}
+	// The scraper is gonna fetch this url from inside our network, so we make sure it's not pointing at something private
+	err := apiCfg.Fetcher.checkURL(ctx, url)
+	if err != nil {
+		return FeedPreview{}, 400, fmt.Errorf("url isn't allowed: %v", err)
+	}
+	// No etag or last modified, we want the whole feed
+	result, err := apiCfg.Fetcher.urlToFeed(ctx, url, "", "")
+
+	// It was a web page, so we go looking for its feed
+	var page *htmlPageError
+	if errors.As(err, &page) {
+		feedURL, found, discoverErr := apiCfg.Fetcher.discoverFeed(ctx, page)
+		var multiple *multipleFeedsError
+		if errors.As(discoverErr, &multiple) {
+			return FeedPreview{}, 300, discoverErr
+		}
+		if discoverErr != nil {
+			return FeedPreview{}, 422, discoverErr
+		}
+		url = feedURL
+		// The page could link anywhere, so the feed's url needs checking too
+		err = apiCfg.Fetcher.checkURL(ctx, url)
+		if err != nil {
+			return FeedPreview{}, 400, fmt.Errorf("url isn't allowed: %v", err)
+		}
+		// If we already fetched the feed while looking for it, there's no need to fetch it again
+		if found != nil {
+			result = *found
+		} else {
+			result, err = apiCfg.Fetcher.urlToFeed(ctx, url, "", "")
+		}
+	}
+	if err != nil {
+		return FeedPreview{}, 422, fmt.Errorf("couldn't fetch feed: %v", err)
+	}
+	// If the feed has moved for good, we'd rather save where it is now
+	if result.MovedTo != "" {
+		url = result.MovedTo
+	}
+	return rssFeedToFeedPreview(url, result.Feed), 0, nil
+}
+
+// respondWithPreviewError responds with what went wrong in previewFeed
+// When a page links to more than one feed, that's not really an error, we send back the list so the client can pick one
+// 300 is Multiple Choices, which is exactly what this is
+func respondWithPreviewError(w http.ResponseWriter, code int, err error) {
+	var multiple *multipleFeedsError
+	if errors.As(err, &multiple) {
+		respondWithJSON(w, code, multiple.candidates)
+		return
+	}
+	repsondWithError(w, code, fmt.Sprintf("Invalid feed: %v", err))
+}
+
 func (apiCfg *apiConfig) handlerGetFeeds(w http.ResponseWriter, r *http.Request) {
 	// feeds is a slice of database.feeds, as it can be more than one row
 	feeds, err := apiCfg.DB.GetFeeds(r.Context())
@@ -57,3 +213,68 @@ func (apiCfg *apiConfig) handlerGetFeeds(w http.ResponseWriter, r *http.Request)
 	// Make the get request and see
 	respondWithJSON(w, 201, databaseFeedstoFeeds(feeds))
 }
+
+// This returns the history of the scraper's fetch attempts for a feed, newest first
+// Like GET /feeds, this isn't authenticated, anyone can see how a feed is doing
+// The feed id is passed in the path, same as we did for deleting feed follows
+func (apiCfg *apiConfig) handlerGetFeedFetches(w http.ResponseWriter, r *http.Request) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't parse feed id: %v", err))
+		return
+	}
+
+	// We check the feed exists first, so u get a 404 instead of an empty list for a feed that doesn't exist
+	_, err = apiCfg.DB.GetFeedByID(r.Context(), feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		repsondWithError(w, 404, "Feed not found")
+		return
+	}
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't get feed: %v", err))
+		return
+	}
+
+	fetches, err := apiCfg.DB.GetFeedFetchLogs(r.Context(), database.GetFeedFetchLogsParams{
+		FeedID: feedID,
+		Limit:  int32(50),
+	})
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't get feed fetches: %v", err))
+		return
+	}
+	respondWithJSON(w, 200, databaseFeedFetchLogsToFeedFetches(fetches))
+}
+
+// When a feed fails too many times in a row, the scraper disables it
+// This lets the owner of the feed turn it back on, like after they fixed the url or the site came back up
+// It's authenticated, as only the user who created the feed is allowed to do this
+func (apiCfg *apiConfig) handlerEnableFeed(w http.ResponseWriter, r *http.Request, user database.User) {
+	feedID, err := uuid.Parse(chi.URLParam(r, "feedID"))
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't parse feed id: %v", err))
+		return
+	}
+
+	feed, err := apiCfg.DB.EnableFeed(r.Context(), database.EnableFeedParams{
+		ID:     feedID,
+		UserID: user.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// No rows means either the feed doesn't exist, or it isn't this user's feed
+		// We look the feed up to tell which one it is, so we can send back the right status code
+		_, err = apiCfg.DB.GetFeedByID(r.Context(), feedID)
+		if err != nil {
+			repsondWithError(w, 404, "Feed not found")
+			return
+		}
+		repsondWithError(w, 403, "Only the owner of a feed can enable it")
+		return
+	}
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't enable feed: %v", err))
+		return
+	}
+
+	respondWithJSON(w, 200, databaseFeedtoFeed(feed))
+}
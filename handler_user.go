@@ -1,9 +1,12 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Yendelevium/RSSAggregator/internal/database"
@@ -96,13 +99,65 @@ func (apiCfg *apiConfig) handlerGetUser(w http.ResponseWriter, r *http.Request,
 // We need a way for the user to access all the posts from the feeds the user is following
 // This will also be an autheticated endpoint, as we need the feeds that the user follows in order to get the posts from those feeds
 func (apiCfg *apiConfig) handlerGetPostsForUser(w http.ResponseWriter, r *http.Request, user database.User) {
+	// The full content of every post can make the response really big, so ?omit_content=true leaves it out
+	// Handy for a list of posts, where u only need the summaries
+	omitContent := false
+	if omitContentString := r.URL.Query().Get("omit_content"); omitContentString != "" {
+		omit, err := strconv.ParseBool(omitContentString)
+		if err != nil {
+			repsondWithError(w, 400, fmt.Sprintf("omit_content must be true or false: %v", err))
+			return
+		}
+		omitContent = omit
+	}
+
+	// ?author=...&category=... only gets the posts with that author/category. Case doesn't matter
+	// Leaving one out (or empty) means no filter, which is a NULL for the query
+	author := strings.TrimSpace(r.URL.Query().Get("author"))
+	category := strings.TrimSpace(r.URL.Query().Get("category"))
+
 	posts, err := apiCfg.DB.GetPostsForUser(r.Context(), database.GetPostsForUserParams{
-		UserID: user.ID,
-		Limit:  int32(10),
+		UserID:   user.ID,
+		Author:   sql.NullString{String: author, Valid: author != ""},
+		Category: sql.NullString{String: category, Valid: category != ""},
+		Limit:    int32(10),
 	})
 	if err != nil {
 		repsondWithError(w, 400, fmt.Sprintf("Couldn't get posts: %v", err))
 		return
 	}
-	respondWithJSON(w, 200, databasePostsToPosts(posts))
+	response := databasePostsToPosts(posts)
+
+	// The enclosures are in their own table, so we get them for all the posts in one query
+	postIDs := []uuid.UUID{}
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+	}
+	enclosures, err := apiCfg.DB.GetEnclosuresForPosts(r.Context(), postIDs)
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't get enclosures: %v", err))
+		return
+	}
+	attachEnclosures(response, enclosures)
+
+	authors, err := apiCfg.DB.GetAuthorsForPosts(r.Context(), postIDs)
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't get authors: %v", err))
+		return
+	}
+	attachAuthors(response, authors)
+
+	categories, err := apiCfg.DB.GetCategoriesForPosts(r.Context(), postIDs)
+	if err != nil {
+		repsondWithError(w, 400, fmt.Sprintf("Couldn't get categories: %v", err))
+		return
+	}
+	attachCategories(response, categories)
+
+	if omitContent {
+		for i := range response {
+			response[i].Content = nil
+		}
+	}
+	respondWithJSON(w, 200, response)
 }
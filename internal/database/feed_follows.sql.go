@@ -98,3 +98,25 @@ func (q *Queries) GetFeedFollows(ctx context.Context, userID uuid.UUID) ([]FeedF
 	}
 	return items, nil
 }
+
+const moveFeedFollows = `-- name: MoveFeedFollows :exec
+
+UPDATE feed_follows
+SET feed_id = $1,
+update_at = NOW()
+WHERE feed_follows.feed_id = $2
+AND feed_follows.user_id NOT IN (SELECT existing.user_id FROM feed_follows existing WHERE existing.feed_id = $1)
+`
+
+type MoveFeedFollowsParams struct {
+	ToFeedID   uuid.UUID
+	FromFeedID uuid.UUID
+}
+
+// When 2 feeds get merged, everyone following the one that goes away follows the one that stays instead
+// Users already following both are skipped, otherwise they'd be following the same feed twice
+// Their old follow gets deleted along with the old feed
+func (q *Queries) MoveFeedFollows(ctx context.Context, arg MoveFeedFollowsParams) error {
+	_, err := q.db.ExecContext(ctx, moveFeedFollows, arg.ToFeedID, arg.FromFeedID)
+	return err
+}
@@ -7,25 +7,101 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const claimNextFeedsToFetch = `-- name: ClaimNextFeedsToFetch :many
+
+UPDATE feeds
+SET last_fetched_at = NOW(),
+update_at = NOW(),
+lease_expires_at = NOW() + make_interval(secs => $2::INT)
+WHERE id IN (
+    SELECT id FROM feeds
+    WHERE disabled_at IS NULL
+    AND (next_fetch_at IS NULL OR next_fetch_at <= NOW())
+    AND (lease_expires_at IS NULL OR lease_expires_at <= NOW())
+    ORDER BY last_fetched_at ASC NULLS FIRST
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, created_at, update_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, disabled_at, hint_interval_seconds, skip_hours, skip_days, lease_expires_at, normalized_url
+`
+
+type ClaimNextFeedsToFetchParams struct {
+	Limit        int32
+	LeaseSeconds int32
+}
+
+// This function will go get the feeds that next need to be fetched, and lease them to the scraper that asked, all in one go
+// First, we wanna find feeds that have never been fectched, and then ordering them, by most recently fetched/ most unrecently fetched, idk how dates work in sql
+// We are also asking the scraper how many feeds it wants
+// Disabled feeds, feeds that are backing off after failing (next_fetch_at in the future), and feeds another scraper
+// is working on (lease_expires_at in the future) are skipped
+// FOR UPDATE locks the rows we pick till the UPDATE is done, and SKIP LOCKED makes other scrapers running this
+// at the same time skip those rows instead of waiting for them. So 2 scrapers can never claim the same feed
+// Claiming a feed also marks it as fetched, which is what MarkFeedAsFetched used to do
+func (q *Queries) ClaimNextFeedsToFetch(ctx context.Context, arg ClaimNextFeedsToFetchParams) ([]Feed, error) {
+	rows, err := q.db.QueryContext(ctx, claimNextFeedsToFetch, arg.Limit, arg.LeaseSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Feed
+	for rows.Next() {
+		var i Feed
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdateAt,
+			&i.Name,
+			&i.Url,
+			&i.UserID,
+			&i.LastFetchedAt,
+			&i.Etag,
+			&i.LastModified,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.NextFetchAt,
+			&i.DisabledAt,
+			&i.HintIntervalSeconds,
+			pq.Array(&i.SkipHours),
+			pq.Array(&i.SkipDays),
+			&i.LeaseExpiresAt,
+			&i.NormalizedUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createFeed = `-- name: CreateFeed :one
 
-INSERT INTO feeds(id,created_at,update_at,name,url,user_id)
-VALUES ($1,$2,$3,$4,$5,$6)
-RETURNING id, created_at, update_at, name, url, user_id, last_fetched_at
+INSERT INTO feeds(id,created_at,update_at,name,url,user_id,normalized_url)
+VALUES ($1,$2,$3,$4,$5,$6,$7)
+RETURNING id, created_at, update_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, disabled_at, hint_interval_seconds, skip_hours, skip_days, lease_expires_at, normalized_url
 `
 
 type CreateFeedParams struct {
-	ID        uuid.UUID
-	CreatedAt time.Time
-	UpdateAt  time.Time
-	Name      string
-	Url       string
-	UserID    uuid.UUID
+	ID            uuid.UUID
+	CreatedAt     time.Time
+	UpdateAt      time.Time
+	Name          string
+	Url           string
+	UserID        uuid.UUID
+	NormalizedUrl string
 }
 
 // We gonna create a query to create a new feed, and to get a new feed
@@ -37,6 +113,7 @@ func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, e
 		arg.Name,
 		arg.Url,
 		arg.UserID,
+		arg.NormalizedUrl,
 	)
 	var i Feed
 	err := row.Scan(
@@ -47,13 +124,148 @@ func (q *Queries) CreateFeed(ctx context.Context, arg CreateFeedParams) (Feed, e
 		&i.Url,
 		&i.UserID,
 		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.DisabledAt,
+		&i.HintIntervalSeconds,
+		pq.Array(&i.SkipHours),
+		pq.Array(&i.SkipDays),
+		&i.LeaseExpiresAt,
+		&i.NormalizedUrl,
+	)
+	return i, err
+}
+
+const deleteFeed = `-- name: DeleteFeed :exec
+
+DELETE FROM feeds WHERE id = $1
+`
+
+// Once a feed has been merged into another one, we delete it
+// Its follows, posts and fetch logs that didn't get moved go with it (ON DELETE CASCADE)
+func (q *Queries) DeleteFeed(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteFeed, id)
+	return err
+}
+
+const enableFeed = `-- name: EnableFeed :one
+
+UPDATE feeds
+SET disabled_at = NULL,
+consecutive_failures = 0,
+next_fetch_at = NULL,
+update_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING id, created_at, update_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, disabled_at, hint_interval_seconds, skip_hours, skip_days, lease_expires_at, normalized_url
+`
+
+type EnableFeedParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+// The owner of a feed can turn it back on after it got disabled
+// We reset the failures too, otherwise the next failure would disable it again straight away
+// Checking the user_id makes it so only the owner can do this, like we did for DeleteFeedFollow
+func (q *Queries) EnableFeed(ctx context.Context, arg EnableFeedParams) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, enableFeed, arg.ID, arg.UserID)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdateAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.DisabledAt,
+		&i.HintIntervalSeconds,
+		pq.Array(&i.SkipHours),
+		pq.Array(&i.SkipDays),
+		&i.LeaseExpiresAt,
+		&i.NormalizedUrl,
+	)
+	return i, err
+}
+
+const getFeedByID = `-- name: GetFeedByID :one
+SELECT id, created_at, update_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, disabled_at, hint_interval_seconds, skip_hours, skip_days, lease_expires_at, normalized_url FROM feeds WHERE id = $1
+`
+
+func (q *Queries) GetFeedByID(ctx context.Context, id uuid.UUID) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedByID, id)
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdateAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.DisabledAt,
+		&i.HintIntervalSeconds,
+		pq.Array(&i.SkipHours),
+		pq.Array(&i.SkipDays),
+		&i.LeaseExpiresAt,
+		&i.NormalizedUrl,
+	)
+	return i, err
+}
+
+const getFeedByNormalizedURLs = `-- name: GetFeedByNormalizedURLs :one
+
+SELECT id, created_at, update_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, disabled_at, hint_interval_seconds, skip_hours, skip_days, lease_expires_at, normalized_url FROM feeds
+WHERE normalized_url = ANY($1::TEXT[])
+ORDER BY created_at ASC
+LIMIT 1
+`
+
+// Looks for a feed whose normalized url is any of these, so we can tell if a feed is already in the db before adding it again
+// There's only ever one feed per normalized url, but the http and https versions are 2 different urls,
+// so if 2 people added them at the same time there could be 2. Then the oldest one wins
+func (q *Queries) GetFeedByNormalizedURLs(ctx context.Context, normalizedUrls []string) (Feed, error) {
+	row := q.db.QueryRowContext(ctx, getFeedByNormalizedURLs, pq.Array(normalizedUrls))
+	var i Feed
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdateAt,
+		&i.Name,
+		&i.Url,
+		&i.UserID,
+		&i.LastFetchedAt,
+		&i.Etag,
+		&i.LastModified,
+		&i.LastError,
+		&i.ConsecutiveFailures,
+		&i.NextFetchAt,
+		&i.DisabledAt,
+		&i.HintIntervalSeconds,
+		pq.Array(&i.SkipHours),
+		pq.Array(&i.SkipDays),
+		&i.LeaseExpiresAt,
+		&i.NormalizedUrl,
 	)
 	return i, err
 }
 
 const getFeeds = `-- name: GetFeeds :many
 
-SELECT id, created_at, update_at, name, url, user_id, last_fetched_at FROM feeds
+SELECT id, created_at, update_at, name, url, user_id, last_fetched_at, etag, last_modified, last_error, consecutive_failures, next_fetch_at, disabled_at, hint_interval_seconds, skip_hours, skip_days, lease_expires_at, normalized_url FROM feeds
 `
 
 // This query is to get all the feeds from our db
@@ -75,6 +287,17 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 			&i.Url,
 			&i.UserID,
 			&i.LastFetchedAt,
+			&i.Etag,
+			&i.LastModified,
+			&i.LastError,
+			&i.ConsecutiveFailures,
+			&i.NextFetchAt,
+			&i.DisabledAt,
+			&i.HintIntervalSeconds,
+			pq.Array(&i.SkipHours),
+			pq.Array(&i.SkipDays),
+			&i.LeaseExpiresAt,
+			&i.NormalizedUrl,
 		); err != nil {
 			return nil, err
 		}
@@ -89,62 +312,149 @@ func (q *Queries) GetFeeds(ctx context.Context) ([]Feed, error) {
 	return items, nil
 }
 
-const getNextFeedsToFetch = `-- name: GetNextFeedsToFetch :many
-SELECT id, created_at, update_at, name, url, user_id, last_fetched_at FROM feeds
-ORDER BY last_fetched_at ASC NULLS FIRST
-LIMIT $1
+const markFeedFetchFailed = `-- name: MarkFeedFetchFailed :exec
+
+UPDATE feeds
+SET last_error = $2,
+consecutive_failures = consecutive_failures + 1,
+next_fetch_at = $3,
+disabled_at = CASE WHEN consecutive_failures + 1 >= $4::INT THEN NOW() ELSE disabled_at END,
+lease_expires_at = NULL
+WHERE id = $1
 `
 
-func (q *Queries) GetNextFeedsToFetch(ctx context.Context, limit int32) ([]Feed, error) {
-	rows, err := q.db.QueryContext(ctx, getNextFeedsToFetch, limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Feed
-	for rows.Next() {
-		var i Feed
-		if err := rows.Scan(
-			&i.ID,
-			&i.CreatedAt,
-			&i.UpdateAt,
-			&i.Name,
-			&i.Url,
-			&i.UserID,
-			&i.LastFetchedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+type MarkFeedFetchFailedParams struct {
+	ID          uuid.UUID
+	LastError   sql.NullString
+	NextFetchAt sql.NullTime
+	MaxFailures int32
 }
 
-const markFeedAsFetched = `-- name: MarkFeedAsFetched :one
+// A fetch failed, so we save the error and count it
+// next_fetch_at is the backoff, which the scraper works out
+// If this failure takes the feed to max_failures failures in a row, the feed gets disabled
+// The scraper is done with the feed, so it lets go of the lease
+func (q *Queries) MarkFeedFetchFailed(ctx context.Context, arg MarkFeedFetchFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markFeedFetchFailed,
+		arg.ID,
+		arg.LastError,
+		arg.NextFetchAt,
+		arg.MaxFailures,
+	)
+	return err
+}
+
+const markFeedFetchSucceeded = `-- name: MarkFeedFetchSucceeded :exec
+
 UPDATE feeds
-SET last_fetched_at = NOW(),
-update_at = NOW()
+SET last_error = NULL,
+consecutive_failures = 0,
+next_fetch_at = $2,
+hint_interval_seconds = $3,
+skip_hours = $4,
+skip_days = $5,
+lease_expires_at = NULL
 WHERE id = $1
-RETURNING id, created_at, update_at, name, url, user_id, last_fetched_at
 `
 
-func (q *Queries) MarkFeedAsFetched(ctx context.Context, id uuid.UUID) (Feed, error) {
-	row := q.db.QueryRowContext(ctx, markFeedAsFetched, id)
-	var i Feed
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdateAt,
-		&i.Name,
-		&i.Url,
-		&i.UserID,
-		&i.LastFetchedAt,
+type MarkFeedFetchSucceededParams struct {
+	ID                  uuid.UUID
+	NextFetchAt         sql.NullTime
+	HintIntervalSeconds sql.NullInt32
+	SkipHours           []int32
+	SkipDays            []int32
+}
+
+// A fetch worked, so the feed is healthy again
+// next_fetch_at is worked out by the scraper from how often the feed posts
+// The schedule hints are saved too, so we still have them when the next fetch is a 304
+// The scraper is done with the feed, so it lets go of the lease
+func (q *Queries) MarkFeedFetchSucceeded(ctx context.Context, arg MarkFeedFetchSucceededParams) error {
+	_, err := q.db.ExecContext(ctx, markFeedFetchSucceeded,
+		arg.ID,
+		arg.NextFetchAt,
+		arg.HintIntervalSeconds,
+		pq.Array(arg.SkipHours),
+		pq.Array(arg.SkipDays),
 	)
-	return i, err
+	return err
+}
+
+const postponeFeedFetch = `-- name: PostponeFeedFetch :exec
+
+UPDATE feeds
+SET last_error = $2,
+next_fetch_at = $3,
+lease_expires_at = NULL
+WHERE id = $1
+`
+
+type PostponeFeedFetchParams struct {
+	ID          uuid.UUID
+	LastError   sql.NullString
+	NextFetchAt sql.NullTime
+}
+
+// The server told us to slow down (a 429 or 503 with a Retry-After header), so we wait as long as it asked
+// This isn't the feed being broken, so we save the error but don't count it as a failure
+// The scraper is done with the feed, so it lets go of the lease
+func (q *Queries) PostponeFeedFetch(ctx context.Context, arg PostponeFeedFetchParams) error {
+	_, err := q.db.ExecContext(ctx, postponeFeedFetch, arg.ID, arg.LastError, arg.NextFetchAt)
+	return err
+}
+
+const releaseFeedLease = `-- name: ReleaseFeedLease :exec
+
+UPDATE feeds
+SET lease_expires_at = NULL
+WHERE id = $1
+`
+
+// If a scraper claimed a feed but didn't get to fetch it (like when it's shutting down), it lets go of the lease
+// so another scraper can pick it up straight away, instead of waiting for the lease to run out
+func (q *Queries) ReleaseFeedLease(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, releaseFeedLease, id)
+	return err
+}
+
+const updateFeedURL = `-- name: UpdateFeedURL :exec
+
+UPDATE feeds
+SET url = $2,
+normalized_url = $3,
+update_at = NOW()
+WHERE id = $1
+`
+
+type UpdateFeedURLParams struct {
+	ID            uuid.UUID
+	Url           string
+	NormalizedUrl string
+}
+
+// When a feed moves for good (a 301 or 308 redirect), we save its new url, and its new normalized url
+func (q *Queries) UpdateFeedURL(ctx context.Context, arg UpdateFeedURLParams) error {
+	_, err := q.db.ExecContext(ctx, updateFeedURL, arg.ID, arg.Url, arg.NormalizedUrl)
+	return err
+}
+
+const updateFeedValidators = `-- name: UpdateFeedValidators :exec
+
+UPDATE feeds
+SET etag = $2,
+last_modified = $3
+WHERE id = $1
+`
+
+type UpdateFeedValidatorsParams struct {
+	ID           uuid.UUID
+	Etag         sql.NullString
+	LastModified sql.NullString
+}
+
+// After a successful fetch, we save the ETag and Last-Modified headers the server sent
+// so we can send them back on the next fetch
+func (q *Queries) UpdateFeedValidators(ctx context.Context, arg UpdateFeedValidatorsParams) error {
+	_, err := q.db.ExecContext(ctx, updateFeedValidators, arg.ID, arg.Etag, arg.LastModified)
+	return err
 }
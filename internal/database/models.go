@@ -11,14 +11,50 @@ import (
 	"github.com/google/uuid"
 )
 
+type Author struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Name      string
+}
+
+type Category struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Name      string
+}
+
 type Feed struct {
-	ID            uuid.UUID
-	CreatedAt     time.Time
-	UpdateAt      time.Time
-	Name          string
-	Url           string
-	UserID        uuid.UUID
-	LastFetchedAt sql.NullTime
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdateAt            time.Time
+	Name                string
+	Url                 string
+	UserID              uuid.UUID
+	LastFetchedAt       sql.NullTime
+	Etag                sql.NullString
+	LastModified        sql.NullString
+	LastError           sql.NullString
+	ConsecutiveFailures int32
+	NextFetchAt         sql.NullTime
+	DisabledAt          sql.NullTime
+	HintIntervalSeconds sql.NullInt32
+	SkipHours           []int32
+	SkipDays            []int32
+	LeaseExpiresAt      sql.NullTime
+	NormalizedUrl       string
+}
+
+type FeedFetchLog struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	FeedID       uuid.UUID
+	StatusCode   sql.NullInt32
+	DurationMs   int32
+	Bytes        int64
+	ItemCount    int32
+	NewPostCount int32
+	Error        sql.NullString
+	MovedFrom    sql.NullString
 }
 
 type FeedFollow struct {
@@ -30,14 +66,46 @@ type FeedFollow struct {
 }
 
 type Post struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdateAt    time.Time
-	Title       string
-	Description sql.NullString
-	PublishedAt time.Time
-	Url         string
-	FeedID      uuid.UUID
+	ID                   uuid.UUID
+	CreatedAt            time.Time
+	UpdateAt             time.Time
+	Title                string
+	Description          sql.NullString
+	PublishedAt          time.Time
+	Url                  string
+	FeedID               uuid.UUID
+	PublishedAtInferred  bool
+	Guid                 string
+	LegacyGuid           bool
+	Revision             int32
+	Content              sql.NullString
+	DescriptionSanitized sql.NullString
+	ContentSanitized     sql.NullString
+	Excerpt              sql.NullString
+}
+
+type PostAuthor struct {
+	PostID   uuid.UUID
+	AuthorID uuid.UUID
+}
+
+type PostCategory struct {
+	PostID     uuid.UUID
+	CategoryID uuid.UUID
+}
+
+type PostEnclosure struct {
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	PostID          uuid.UUID
+	Kind            string
+	Url             string
+	MimeType        sql.NullString
+	Length          sql.NullInt64
+	DurationSeconds sql.NullInt32
+	ImageUrl        sql.NullString
+	Episode         sql.NullInt32
+	Season          sql.NullInt32
 }
 
 type User struct {
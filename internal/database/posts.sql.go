@@ -13,71 +13,99 @@ import (
 	"github.com/google/uuid"
 )
 
-const createPost = `-- name: CreatePost :one
-INSERT INTO posts(id,
-    created_at,
-    update_at,
-    title, 
-    description,
-    published_at,
-    url,
-    feed_id
+const adoptLegacyPost = `-- name: AdoptLegacyPost :exec
+
+UPDATE posts
+SET guid = $1, legacy_guid = false
+WHERE posts.id = (
+    SELECT legacy.id FROM posts legacy
+    WHERE legacy.feed_id = $2
+    AND legacy.url = $3
+    AND legacy.legacy_guid
+    LIMIT 1
+)
+AND NOT EXISTS (
+    SELECT 1 FROM posts existing
+    WHERE existing.feed_id = $2 AND existing.guid = $1 AND existing.id <> posts.id
 )
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
-RETURNING id, created_at, update_at, title, description, published_at, url, feed_id
 `
 
-type CreatePostParams struct {
-	ID          uuid.UUID
-	CreatedAt   time.Time
-	UpdateAt    time.Time
-	Title       string
-	Description sql.NullString
-	PublishedAt time.Time
-	Url         string
-	FeedID      uuid.UUID
+type AdoptLegacyPostParams struct {
+	Guid   string
+	FeedID uuid.UUID
+	Url    string
 }
 
-func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, error) {
-	row := q.db.QueryRowContext(ctx, createPost,
-		arg.ID,
-		arg.CreatedAt,
-		arg.UpdateAt,
-		arg.Title,
-		arg.Description,
-		arg.PublishedAt,
-		arg.Url,
-		arg.FeedID,
-	)
-	var i Post
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdateAt,
-		&i.Title,
-		&i.Description,
-		&i.PublishedAt,
-		&i.Url,
-		&i.FeedID,
-	)
-	return i, err
+// Before posts had guids, 008_posts_guid.sql gave every post we had its url as its guid, and flagged it with legacy_guid
+// So when we see a post whose guid we don't have, it could still be one of those old rows. If it is, it gets the
+// post's real guid, so it doesn't get saved a second time
+// Only the flagged rows are taken over, coz some feeds really do have different posts with the same url,
+// and a post that got its link as its guid coz it has no guid isn't an old copy of anything
+// The flag gets cleared either way, so each old row is only ever looked at once
+func (q *Queries) AdoptLegacyPost(ctx context.Context, arg AdoptLegacyPostParams) error {
+	_, err := q.db.ExecContext(ctx, adoptLegacyPost, arg.Guid, arg.FeedID, arg.Url)
+	return err
+}
+
+const getPostIDByGUID = `-- name: GetPostIDByGUID :one
+
+SELECT id FROM posts
+WHERE feed_id = $1 AND guid = $2
+`
+
+type GetPostIDByGUIDParams struct {
+	FeedID uuid.UUID
+	Guid   string
+}
+
+// When a post hasn't changed, UpsertPost doesn't return it, but the scraper still needs its id to save its enclosures, authors and categories
+func (q *Queries) GetPostIDByGUID(ctx context.Context, arg GetPostIDByGUIDParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getPostIDByGUID, arg.FeedID, arg.Guid)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
 }
 
 const getPostsForUser = `-- name: GetPostsForUser :many
-SELECT posts.id, posts.created_at, posts.update_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id from posts
+
+SELECT posts.id, posts.created_at, posts.update_at, posts.title, posts.description, posts.published_at, posts.url, posts.feed_id, posts.published_at_inferred, posts.guid, posts.legacy_guid, posts.revision, posts.content, posts.description_sanitized, posts.content_sanitized, posts.excerpt from posts
 JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
 WHERE feed_follows.user_id = $1
+AND ($2::TEXT IS NULL OR EXISTS (
+    SELECT 1 FROM post_authors
+    JOIN authors ON authors.id = post_authors.author_id
+    WHERE post_authors.post_id = posts.id AND lower(authors.name) = lower($2)
+))
+AND ($3::TEXT IS NULL OR EXISTS (
+    SELECT 1 FROM post_categories
+    JOIN categories ON categories.id = post_categories.category_id
+    WHERE post_categories.post_id = posts.id AND lower(categories.name) = lower($3)
+))
 ORDER BY posts.published_at DESC
-LIMIT $2
+LIMIT $4
 `
 
 type GetPostsForUserParams struct {
-	UserID uuid.UUID
-	Limit  int32
+	UserID   uuid.UUID
+	Author   sql.NullString
+	Category sql.NullString
+	Limit    int32
 }
 
+// Ok, this query is gonna be a little more complex
+// Basically, we just wanna get the posts from the feeds that the user is following
+// To know that, we gotta use a join, to get only the posts, who have feed_ids that the user is following
+// We also take the user_id as input as we gotta know who we want to get the feeds for
+// And also we r ordering them as most recent, and limiting how many posts we get per request
+// author and category are optional filters. When they're NULL they don't filter anything, otherwise
+// we only get posts that have that author/category (ignoring case)
 func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams) ([]Post, error) {
-	rows, err := q.db.QueryContext(ctx, getPostsForUser, arg.UserID, arg.Limit)
+	rows, err := q.db.QueryContext(ctx, getPostsForUser,
+		arg.UserID,
+		arg.Author,
+		arg.Category,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +122,14 @@ func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams
 			&i.PublishedAt,
 			&i.Url,
 			&i.FeedID,
+			&i.PublishedAtInferred,
+			&i.Guid,
+			&i.LegacyGuid,
+			&i.Revision,
+			&i.Content,
+			&i.DescriptionSanitized,
+			&i.ContentSanitized,
+			&i.Excerpt,
 		); err != nil {
 			return nil, err
 		}
@@ -107,3 +143,169 @@ func (q *Queries) GetPostsForUser(ctx context.Context, arg GetPostsForUserParams
 	}
 	return items, nil
 }
+
+const getRecentPublishDates = `-- name: GetRecentPublishDates :many
+
+SELECT published_at FROM posts
+WHERE feed_id = $1 AND NOT published_at_inferred
+ORDER BY published_at DESC
+LIMIT $2
+`
+
+type GetRecentPublishDatesParams struct {
+	FeedID uuid.UUID
+	Limit  int32
+}
+
+// The scraper uses this to work out how often a feed posts, so it knows how often to fetch it
+// Inferred dates are just when we fetched the post, so they'd mess up the math, hence we skip them
+func (q *Queries) GetRecentPublishDates(ctx context.Context, arg GetRecentPublishDatesParams) ([]time.Time, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentPublishDates, arg.FeedID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []time.Time
+	for rows.Next() {
+		var published_at time.Time
+		if err := rows.Scan(&published_at); err != nil {
+			return nil, err
+		}
+		items = append(items, published_at)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const movePosts = `-- name: MovePosts :exec
+
+UPDATE posts
+SET feed_id = $1
+WHERE posts.feed_id = $2
+AND posts.guid NOT IN (SELECT existing.guid FROM posts existing WHERE existing.feed_id = $1)
+`
+
+type MovePostsParams struct {
+	ToFeedID   uuid.UUID
+	FromFeedID uuid.UUID
+}
+
+// When 2 feeds get merged, the posts of the one that goes away move to the one that stays
+// Posts the other feed already has (same guid) are skipped, and get deleted along with the old feed
+func (q *Queries) MovePosts(ctx context.Context, arg MovePostsParams) error {
+	_, err := q.db.ExecContext(ctx, movePosts, arg.ToFeedID, arg.FromFeedID)
+	return err
+}
+
+const upsertPost = `-- name: UpsertPost :one
+
+INSERT INTO posts(id,
+    created_at,
+    update_at,
+    title, 
+    description,
+    published_at,
+    url,
+    feed_id,
+    published_at_inferred,
+    guid,
+    content,
+    description_sanitized,
+    content_sanitized,
+    excerpt
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+ON CONFLICT (feed_id, guid) DO UPDATE
+SET title = EXCLUDED.title,
+    description = EXCLUDED.description,
+    content = EXCLUDED.content,
+    description_sanitized = EXCLUDED.description_sanitized,
+    content_sanitized = EXCLUDED.content_sanitized,
+    excerpt = EXCLUDED.excerpt,
+    published_at = CASE WHEN EXCLUDED.published_at_inferred THEN posts.published_at ELSE EXCLUDED.published_at END,
+    published_at_inferred = posts.published_at_inferred AND EXCLUDED.published_at_inferred,
+    url = EXCLUDED.url,
+    update_at = CASE WHEN posts.title IS DISTINCT FROM EXCLUDED.title
+        OR posts.description IS DISTINCT FROM EXCLUDED.description
+        OR (posts.content IS NOT NULL AND posts.content IS DISTINCT FROM EXCLUDED.content)
+        OR (NOT EXCLUDED.published_at_inferred AND posts.published_at IS DISTINCT FROM EXCLUDED.published_at)
+        THEN EXCLUDED.update_at ELSE posts.update_at END,
+    revision = CASE WHEN posts.title IS DISTINCT FROM EXCLUDED.title
+        OR posts.description IS DISTINCT FROM EXCLUDED.description
+        OR (posts.content IS NOT NULL AND posts.content IS DISTINCT FROM EXCLUDED.content)
+        OR (NOT EXCLUDED.published_at_inferred AND posts.published_at IS DISTINCT FROM EXCLUDED.published_at)
+        THEN posts.revision + 1 ELSE posts.revision END
+WHERE posts.title IS DISTINCT FROM EXCLUDED.title
+    OR posts.description IS DISTINCT FROM EXCLUDED.description
+    OR posts.content IS DISTINCT FROM EXCLUDED.content
+    OR (NOT EXCLUDED.published_at_inferred AND posts.published_at IS DISTINCT FROM EXCLUDED.published_at)
+RETURNING id, created_at, update_at, title, description, published_at, url, feed_id, published_at_inferred, guid, legacy_guid, revision, content, description_sanitized, content_sanitized, excerpt
+`
+
+type UpsertPostParams struct {
+	ID                   uuid.UUID
+	CreatedAt            time.Time
+	UpdateAt             time.Time
+	Title                string
+	Description          sql.NullString
+	PublishedAt          time.Time
+	Url                  string
+	FeedID               uuid.UUID
+	PublishedAtInferred  bool
+	Guid                 string
+	Content              sql.NullString
+	DescriptionSanitized sql.NullString
+	ContentSanitized     sql.NullString
+	Excerpt              sql.NullString
+}
+
+// UpsertPost creates the post, or updates it if we already have a post with the same guid in this feed
+// The WHERE on the DO UPDATE makes it so that we only touch the row if something actually changed
+// If nothing changed, no row is returned at all, so the go code gets an sql.ErrNoRows
+// If the date is inferred (the feed has no date we could parse), we keep the date we already had, otherwise
+// every scrape would count as an edit coz the fetch time is always different
+// Posts saved before we stored content (015_posts_content.sql) have a NULL content. Filling that in isn't the post
+// being edited, so it doesn't bump the revision or update_at. That's how the scraper tells the two apart
+func (q *Queries) UpsertPost(ctx context.Context, arg UpsertPostParams) (Post, error) {
+	row := q.db.QueryRowContext(ctx, upsertPost,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdateAt,
+		arg.Title,
+		arg.Description,
+		arg.PublishedAt,
+		arg.Url,
+		arg.FeedID,
+		arg.PublishedAtInferred,
+		arg.Guid,
+		arg.Content,
+		arg.DescriptionSanitized,
+		arg.ContentSanitized,
+		arg.Excerpt,
+	)
+	var i Post
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdateAt,
+		&i.Title,
+		&i.Description,
+		&i.PublishedAt,
+		&i.Url,
+		&i.FeedID,
+		&i.PublishedAtInferred,
+		&i.Guid,
+		&i.LegacyGuid,
+		&i.Revision,
+		&i.Content,
+		&i.DescriptionSanitized,
+		&i.ContentSanitized,
+		&i.Excerpt,
+	)
+	return i, err
+}
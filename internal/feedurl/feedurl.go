@@ -0,0 +1,95 @@
+package feedurl
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// The same feed can be written a bunch of different ways:
+// http://x.com/feed, https://x.com/feed/, https://X.com:443/feed?utm_source=a
+// The feeds.url UNIQUE constraint thinks these are all different, so we'd end up scraping the same feed over and over
+// Normalize turns them all into the same url, so we can spot the duplicates
+
+// Query parameters that are just for tracking clicks, and never change what the server sends back
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+	"_ga":     true,
+}
+
+// Normalize cleans up a feed url:
+// - lowercases the scheme and host, and drops default ports (:80 for http, :443 for https)
+// - drops the trailing slash from the path, and the #fragment (which never gets sent to the server anyway)
+// - drops tracking parameters like utm_source, and sorts the rest so their order doesn't matter
+// It doesn't touch the scheme, coz not every site that has http has https. Use Variants for that
+func Normalize(rawURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("url must be http or https")
+	}
+	if u.Host == "" {
+		return "", errors.New("url has no host")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	// Hostname() takes the [] off IPv6 addresses, so we have to put them back
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	// /feed/ and /feed are the same feed. The root path is just /
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := u.Query()
+	for key := range query {
+		if trackingParams[strings.ToLower(key)] || strings.HasPrefix(strings.ToLower(key), "utm_") {
+			query.Del(key)
+		}
+	}
+	// Sorting the values, so ?a=2&a=1 and ?a=1&a=2 match. Encode sorts the parameters by key for us
+	for key := range query {
+		sort.Strings(query[key])
+	}
+	u.RawQuery = query.Encode()
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// Variants returns the http and https versions of a normalized url
+// A feed added as http:// and as https:// is still the same feed, so when we look for duplicates we look for both
+func Variants(normalizedURL string) []string {
+	if rest, ok := strings.CutPrefix(normalizedURL, "https://"); ok {
+		return []string{normalizedURL, "http://" + rest}
+	}
+	if rest, ok := strings.CutPrefix(normalizedURL, "http://"); ok {
+		return []string{normalizedURL, "https://" + rest}
+	}
+	return []string{normalizedURL}
+}
@@ -0,0 +1,72 @@
+//go:build integration
+
+package feedurl
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// 019_feeds_normalized_url.sql fills in normalized_url for the feeds we already had with a SQL copy of Normalize
+// If the two ever disagree, the unique index won't catch duplicates between old feeds and new ones
+// So this runs every normalizeTests case through both. It needs a Postgres, but not the migrations:
+//
+//	DB_URL=... go test -tags integration ./internal/feedurl
+func TestNormalizeMatchesMigration(t *testing.T) {
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		t.Skip("DB_URL isn't set")
+	}
+	migration, err := os.ReadFile("../../sql/schema/019_feeds_normalized_url.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The function is everything between goose's StatementBegin and StatementEnd
+	_, function, ok := strings.Cut(string(migration), "-- +goose StatementBegin")
+	if ok {
+		function, _, ok = strings.Cut(function, "-- +goose StatementEnd")
+	}
+	if !ok {
+		t.Fatal("couldn't find normalize_feed_url in the migration")
+	}
+	// pg_temp, so it only exists for this connection and we don't leave anything behind
+	function = strings.Replace(function, "CREATE FUNCTION normalize_feed_url", "CREATE FUNCTION pg_temp.normalize_feed_url", 1)
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	// Temp stuff only lives on one connection, so we stick to one
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, function); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range normalizeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := Normalize(tt.url)
+			if err != nil {
+				t.Fatalf("Normalize(%q) failed: %v", tt.url, err)
+			}
+			var got string
+			err = conn.QueryRowContext(ctx, "SELECT pg_temp.normalize_feed_url($1)", tt.url).Scan(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("normalize_feed_url(%q) = %q, Normalize gives %q", tt.url, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// Users can give us any url as a feed, and the scraper GETs it from inside our network
+// Without this, someone could add http://169.254.169.254/ (the cloud metadata server) or http://localhost:5432 as a "feed"
+// and get our server to make requests it really shouldn't. That's called SSRF (server side request forgery)
+
+// So the Guard blocks private, loopback and link-local addresses in 2 places:
+// - CheckURL, when a feed gets created, so the user gets told straight away
+// - DialContext, every time the fetcher actually connects to something. This one is the important one, coz it checks
+//   the IP we're actually connecting to, after DNS. So a hostname that resolves to 127.0.0.1 (or starts resolving to it later),
+//   or a feed that redirects to a private address, still gets blocked
+
+// ErrBlocked is what u get when a url or address isn't allowed. Check for it with errors.Is
+var ErrBlocked = errors.New("address is not allowed")
+
+// These aren't covered by the netip.Addr methods, but we don't wanna connect to them either
+var blockedPrefixes = []netip.Prefix{
+	// "This network"
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// Carrier grade NAT, which a lot of cloud providers use internally
+	netip.MustParsePrefix("100.64.0.0/10"),
+	// IETF protocol assignments
+	netip.MustParsePrefix("192.0.0.0/24"),
+	// Documentation, nothing real lives here
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	// Benchmarking
+	netip.MustParsePrefix("198.18.0.0/15"),
+	// Reserved, and 255.255.255.255 (broadcast) is in here too
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// NAT64 for the local network, it's up to the network where the IPv4 address goes in these, so we can't pull it out
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// Some IPv6 addresses are really just an IPv4 address with extra steps, and the network will happily route them there
+// So http://[64:ff9b::a9fe:a9fe]/ can end up at 169.254.169.254. For these we pull the IPv4 address out and check that instead
+var (
+	// NAT64, the IPv4 address is the last 32 bits
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	// 6to4, the IPv4 address is the 32 bits right after 2002:
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+	// The old IPv4 compatible addresses, like ::127.0.0.1. The IPv4 address is the last 32 bits
+	ipv4CompatiblePrefix = netip.MustParsePrefix("::/96")
+)
+
+type Guard struct {
+	// Hostnames that are always allowed, even if they resolve to a private address, like a self hosted feed on the same network
+	allowedHosts map[string]bool
+	// Same thing, but for IPs and IP ranges
+	allowedPrefixes []netip.Prefix
+}
+
+// New makes a Guard. allowlist is a list of hostnames, IPs and CIDR ranges (like 10.0.0.0/8) that are allowed anyway
+func New(allowlist []string) (*Guard, error) {
+	g := &Guard{allowedHosts: map[string]bool{}}
+	for _, entry := range allowlist {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowlist entry %v: %v", entry, err)
+			}
+			g.allowedPrefixes = append(g.allowedPrefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			g.allowedPrefixes = append(g.allowedPrefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		g.allowedHosts[entry] = true
+	}
+	return g, nil
+}
+
+// CheckURL checks that rawURL is an http(s) url that doesn't point at a blocked address
+// If the host is a name, we look it up, and every address it resolves to has to be allowed
+func (g *Guard) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("couldn't parse url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url must be http or https, not %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.New("url has no host")
+	}
+	if g.allowedHosts[host] {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return g.checkAddr(addr)
+	}
+	// localhost doesn't always go through DNS, so we don't even try
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %v", ErrBlocked, host)
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("couldn't look up %v: %v", host, err)
+	}
+	for _, addr := range addrs {
+		if err := g.checkAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DialContext wraps dialer so it refuses to connect to blocked addresses. Use it as the DialContext of a http.Transport
+// Allowed hostnames skip the check, everything else gets checked in the dialer's Control hook,
+// which runs after DNS with the actual IP we're about to connect to
+// If u use a proxy, the connection is to the proxy, so a proxy on a private address has to be in the allowlist,
+// and the dialer never sees where the request is really going. Then u have to CheckURL every url urself before fetching it
+func (g *Guard) DialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	guarded := *dialer
+	guarded.Control = func(network, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBlocked, address)
+		}
+		return g.checkAddr(addrPort.Addr())
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil && g.allowedHosts[strings.ToLower(host)] {
+			return dialer.DialContext(ctx, network, address)
+		}
+		return guarded.DialContext(ctx, network, address)
+	}
+}
+
+// checkAddr errors if addr is private, loopback, link-local etc, and not in the allowlist
+func (g *Guard) checkAddr(addr netip.Addr) error {
+	// ::ffff:127.0.0.1 is just 127.0.0.1 in IPv6 clothing
+	addr = addr.Unmap()
+	if v4, ok := embeddedIPv4(addr); ok {
+		addr = v4
+	}
+	for _, prefix := range g.allowedPrefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if !isPublic(addr) {
+		return fmt.Errorf("%w: %v", ErrBlocked, addr)
+	}
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// embeddedIPv4 gives back the IPv4 address inside a NAT64, 6to4 or IPv4 compatible address, if addr is one of those
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	if !addr.Is6() {
+		return netip.Addr{}, false
+	}
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case sixToFourPrefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	case ipv4CompatiblePrefix.Contains(addr):
+		// :: and ::1 are in here too, but those aren't IPv4 addresses, and isPublic blocks them anyway
+		if addr.IsUnspecified() || addr.IsLoopback() {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,150 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestCheckAddr(t *testing.T) {
+	g, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"100.64.0.1", true},
+		{"192.0.0.8", true},
+		{"198.18.0.1", true},
+		{"240.0.0.1", true},
+		{"255.255.255.255", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:93.184.216.34", false},
+		// IPv4 inside IPv6, these get checked as the IPv4 address
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::5db8:d822", false},
+		{"64:ff9b:1::1", true},
+		{"2002:7f00:1::", true},
+		{"2002:a9fe:a9fe::1", true},
+		{"2002:5db8:d822::1", false},
+		{"::127.0.0.1", true},
+		{"::10.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := g.checkAddr(netip.MustParseAddr(tt.addr))
+			if tt.blocked && !errors.Is(err, ErrBlocked) {
+				t.Errorf("checkAddr(%v) = %v, want ErrBlocked", tt.addr, err)
+			}
+			if !tt.blocked && err != nil {
+				t.Errorf("checkAddr(%v) = %v, want nil", tt.addr, err)
+			}
+		})
+	}
+}
+
+// These all use IPs or names that never get looked up, so they don't need DNS
+func TestCheckURL(t *testing.T) {
+	g, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+		blocked bool
+	}{
+		{"public ip", "http://93.184.216.34/feed.xml", false, false},
+		{"public ipv6", "https://[2606:2800:220:1:248:1893:25c8:1946]/feed", false, false},
+		{"loopback", "http://127.0.0.1:8080/", true, true},
+		{"metadata server", "http://169.254.169.254/latest/meta-data/", true, true},
+		{"localhost", "http://localhost/feed", true, true},
+		{"localhost subdomain", "http://app.localhost/feed", true, true},
+		{"uppercase localhost", "http://LOCALHOST/feed", true, true},
+		{"nat64", "http://[64:ff9b::a9fe:a9fe]/", true, true},
+		{"6to4", "http://[2002:7f00:1::]/", true, true},
+		{"ipv4 compatible", "http://[::127.0.0.1]/", true, true},
+		{"mapped ipv4", "http://[::ffff:10.0.0.1]/", true, true},
+		{"ftp", "ftp://93.184.216.34/feed.xml", true, false},
+		{"file", "file:///etc/passwd", true, false},
+		{"no host", "http:///feed.xml", true, false},
+		{"garbage", "http://%zz", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.CheckURL(context.Background(), tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckURL(%v) = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if errors.Is(err, ErrBlocked) != tt.blocked {
+				t.Errorf("CheckURL(%v) = %v, blocked %v", tt.url, err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	g, err := New([]string{" Feeds.Internal ", "10.0.0.0/8", "192.168.1.5", "fd00::/8", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		// Allowed hostnames don't even get looked up
+		{"allowed host", "http://feeds.internal/rss", false},
+		{"allowed host any case", "http://FEEDS.internal/rss", false},
+		{"allowed range", "http://10.20.30.40/rss", false},
+		{"allowed ip", "http://192.168.1.5/rss", false},
+		{"allowed ip mapped", "http://[::ffff:192.168.1.5]/rss", false},
+		{"allowed ipv6 range", "http://[fd12::1]/rss", false},
+		{"next ip over", "http://192.168.1.6/rss", true},
+		{"outside range", "http://172.16.0.1/rss", true},
+		{"still no loopback", "http://127.0.0.1/rss", true},
+		{"still no localhost", "http://localhost/rss", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.CheckURL(context.Background(), tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckURL(%v) = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewInvalidAllowlist(t *testing.T) {
+	tests := []string{"10.0.0.0/33", "not/a/range", "300.0.0.0/8"}
+	for _, entry := range tests {
+		t.Run(entry, func(t *testing.T) {
+			if _, err := New([]string{entry}); err == nil {
+				t.Errorf("New(%q) = nil error, want one", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package sanitize
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// Post descriptions and content are html from whoever runs the feed, and clients render them
+// So a feed could put a <script> or an onerror="..." in a post, and it'd run in our users' browsers
+// HTML cleans that html up, keeping only the tags and attributes on the allowlist below
+// Anything not on the list gets dropped (but the text inside it stays), except for tags like <script>
+// where we drop everything inside them too
+
+// The tags we keep, and the attributes each one is allowed to have
+var allowedTags = map[string][]string{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"b":          nil,
+	"blockquote": {"cite"},
+	"br":         nil,
+	"caption":    nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        nil,
+	"li":         nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"q":          {"cite"},
+	"s":          nil,
+	"small":      nil,
+	"span":       nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"colspan", "rowspan", "scope"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+// These tags get dropped along with everything inside them, coz what's inside isn't text anyone should see
+var droppedWithContent = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"iframe":   true,
+	"object":   true,
+	"embed":    true,
+	"noscript": true,
+	"template": true,
+	"svg":      true,
+	"math":     true,
+	"head":     true,
+	"title":    true,
+	"form":     true,
+	"select":   true,
+	"textarea": true,
+}
+
+// Tags that start a new line or block, so the text on either side of them are separate words
+var blockTags = map[string]bool{
+	"p": true, "br": true, "div": true, "li": true, "ul": true, "ol": true, "dl": true, "dt": true, "dd": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "blockquote": true, "pre": true,
+	"hr": true, "table": true, "tr": true, "td": true, "th": true, "figure": true, "figcaption": true, "img": true,
+}
+
+// Attributes that hold urls. They get resolved against the post's link, and only some schemes are allowed
+var urlAttrs = map[string]bool{"href": true, "src": true, "cite": true}
+
+// Hosts that only serve tracking pixels, so any image from them is a tracker
+var trackerHosts = []string{
+	"feeds.feedburner.com",
+	"feedproxy.google.com",
+	"pixel.wp.com",
+	"stats.wordpress.com",
+	"www.google-analytics.com",
+	"pixel.quantserve.com",
+	"www.facebook.com",
+}
+
+// HTML returns a safe version of body. base is the post's link, which relative links and images are resolved against
+// base can be nil, then relative urls are dropped, coz they'd be relative to whatever page the client shows them on
+func HTML(body string, base *url.URL) string {
+	var out strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	// How deep we are inside a tag from droppedWithContent. While it's above 0, we skip everything
+	skipDepth := 0
+	// The tags we kept that haven't been closed yet, innermost last
+	// The tokenizer gives us closing tags even for the opening tags we dropped, so this is how we know which to keep
+	open := []string{}
+
+	for {
+		tokenType := tokenizer.Next()
+		// ErrorToken is io.EOF when we get to the end. With any other error we just go with what we have
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedWithContent[token.Data] {
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			attrs, ok := allowedTags[token.Data]
+			if !ok {
+				continue
+			}
+			token.Attr = cleanAttrs(token.Data, token.Attr, attrs, base)
+			// An image with no src left (like a tracking pixel we took out) isn't worth keeping
+			if token.Data == "img" && !hasAttr(token.Attr, "src") {
+				continue
+			}
+			if token.Data == "a" {
+				// Links open in a new tab, and can't mess with the page that opened them
+				token.Attr = append(token.Attr,
+					html.Attribute{Key: "rel", Val: "nofollow noopener noreferrer"},
+					html.Attribute{Key: "target", Val: "_blank"},
+				)
+			}
+			if tokenType == html.StartTagToken && !isVoid(token.Data) {
+				open = append(open, token.Data)
+			}
+			out.WriteString(token.String())
+		case html.EndTagToken:
+			if droppedWithContent[token.Data] {
+				if skipDepth > 0 {
+					skipDepth--
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			// Closing the tag, and anything inside it the feed forgot to close, so the tags always nest properly
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != token.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					out.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			// token.String() escapes the text again, so something like &lt;script&gt; stays as text
+			out.WriteString(token.String())
+		}
+		// Comments and doctypes just get dropped
+	}
+
+	// Closing anything the feed left open, so our html can't break the page it's shown on
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// cleanAttrs keeps only the allowed attributes of a tag, and makes sure urls are absolute and safe
+func cleanAttrs(tag string, attrs []html.Attribute, allowed []string, base *url.URL) []html.Attribute {
+	cleaned := []html.Attribute{}
+	for _, attr := range attrs {
+		if attr.Namespace != "" || !contains(allowed, attr.Key) {
+			continue
+		}
+		if urlAttrs[attr.Key] {
+			resolved, ok := resolveURL(attr.Val, base, tag == "a" && attr.Key == "href")
+			if !ok {
+				continue
+			}
+			attr.Val = resolved
+		}
+		cleaned = append(cleaned, attr)
+	}
+	if tag == "img" && isTrackingPixel(cleaned) {
+		return nil
+	}
+	return cleaned
+}
+
+// URL makes raw absolute against base, and checks it's http(s), the same way HTML does for images
+// It's for urls that end up somewhere other than a post body, like podcast enclosures
+// An empty url is dropped too, instead of being resolved to base itself
+func URL(raw string, base *url.URL) (string, bool) {
+	if strings.TrimSpace(raw) == "" {
+		return "", false
+	}
+	return resolveURL(raw, base, false)
+}
+
+// resolveURL makes a url absolute, and checks it's http(s). Links are allowed to be mailto: too
+// javascript:, data: and everything else get dropped
+func resolveURL(raw string, base *url.URL, isLink bool) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false
+	}
+	if !u.IsAbs() {
+		if base == nil {
+			return "", false
+		}
+		u = base.ResolveReference(u)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.String(), true
+	case "mailto":
+		return u.String(), isLink
+	}
+	return "", false
+}
+
+// isTrackingPixel checks if an image is there just to track who opened the post
+// They're 1x1 (or 0x0) images, or come from a host that only does tracking
+func isTrackingPixel(attrs []html.Attribute) bool {
+	width, height := -1, -1
+	for _, attr := range attrs {
+		switch attr.Key {
+		case "width":
+			if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(attr.Val), "px")); err == nil {
+				width = n
+			}
+		case "height":
+			if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(attr.Val), "px")); err == nil {
+				height = n
+			}
+		case "src":
+			if u, err := url.Parse(attr.Val); err == nil {
+				for _, host := range trackerHosts {
+					if strings.EqualFold(u.Hostname(), host) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return width >= 0 && width <= 1 && height >= 0 && height <= 1
+}
+
+// Text turns html into plain text, with all the whitespace squashed down to single spaces
+func Text(body string) string {
+	var text strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	skipDepth := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedWithContent[token.Data] && tokenType == html.StartTagToken {
+				skipDepth++
+			}
+			// Block tags like <p> and <br> separate words, even if there's no space in the html
+			if blockTags[token.Data] {
+				text.WriteString(" ")
+			}
+		case html.EndTagToken:
+			if droppedWithContent[token.Data] && skipDepth > 0 {
+				skipDepth--
+			}
+			if blockTags[token.Data] {
+				text.WriteString(" ")
+			}
+		case html.TextToken:
+			if skipDepth == 0 {
+				// token.Data is already unescaped, so &amp; is just & here
+				text.WriteString(token.Data)
+			}
+		}
+	}
+
+	// Squashing all the whitespace down to single spaces
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// Excerpt turns html into plain text, and cuts it down to at most maxRunes characters
+// It cuts at the end of a word, and adds … if it had to cut anything
+func Excerpt(body string, maxRunes int) string {
+	excerpt := Text(body)
+	runes := []rune(excerpt)
+	if len(runes) <= maxRunes {
+		return excerpt
+	}
+	cut := string(runes[:maxRunes])
+	// Going back to the last space, so we don't cut a word in half
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsPunct(r) }) + "…"
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttr(attrs []html.Attribute, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Void tags never have a closing tag
+func isVoid(tag string) bool {
+	return tag == "br" || tag == "hr" || tag == "img"
+}
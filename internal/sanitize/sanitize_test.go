@@ -0,0 +1,265 @@
+package sanitize
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	base, err := url.Parse("https://blog.example.com/posts/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "allowed tags stay",
+			body: "<p>Hello <strong>there</strong>, <em>u</em></p>",
+			want: "<p>Hello <strong>there</strong>, <em>u</em></p>",
+		},
+		{
+			name: "unknown tags go but their text stays",
+			body: "<p>Hi <blink>there</blink></p>",
+			want: "<p>Hi there</p>",
+		},
+		{
+			name: "script",
+			body: "<p>Hi</p><script>alert(1)</script><p>Bye</p>",
+			want: "<p>Hi</p><p>Bye</p>",
+		},
+		{
+			name: "style",
+			body: "<style>body { display: none }</style><p>Hi</p>",
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "svg",
+			body: `<p>Hi</p><svg onload="alert(1)"><script>alert(2)</script><text>svg text</text></svg>`,
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "math",
+			body: "<p>Hi</p><math><mi>x</mi><mtext><script>alert(1)</script></mtext></math>",
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "noscript",
+			body: "<p>Hi</p><noscript><img src=x onerror=alert(1)></noscript>",
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "nested dropped tags",
+			body: "<iframe><script>a</script>b</iframe><p>Hi</p>",
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "event handlers",
+			body: `<p onclick="alert(1)">Hi <img src="https://cdn.example.com/a.png" onerror="alert(2)" ONLOAD="alert(3)"></p>`,
+			want: `<p>Hi <img src="https://cdn.example.com/a.png"></p>`,
+		},
+		{
+			name: "style and class attributes",
+			body: `<span style="position:fixed" class="x" id="y">Hi</span>`,
+			want: "<span>Hi</span>",
+		},
+		{
+			name: "javascript href",
+			body: `<a href="javascript:alert(1)">click</a>`,
+			want: `<a rel="nofollow noopener noreferrer" target="_blank">click</a>`,
+		},
+		{
+			name: "javascript href with caps and spaces",
+			body: `<a href="  JavaScript:alert(1)">click</a>`,
+			want: `<a rel="nofollow noopener noreferrer" target="_blank">click</a>`,
+		},
+		{
+			name: "data href",
+			body: `<a href="data:text/html;base64,PHNjcmlwdD4=">click</a>`,
+			want: `<a rel="nofollow noopener noreferrer" target="_blank">click</a>`,
+		},
+		{
+			name: "javascript src",
+			body: `<p>Hi<img src="javascript:alert(1)"></p>`,
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "data src",
+			body: `<p>Hi<img src="data:image/svg+xml;base64,PHN2Zz4="></p>`,
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "mailto links are fine",
+			body: `<a href="mailto:me@example.com">mail me</a>`,
+			want: `<a href="mailto:me@example.com" rel="nofollow noopener noreferrer" target="_blank">mail me</a>`,
+		},
+		{
+			name: "but not mailto images",
+			body: `<img src="mailto:me@example.com">`,
+			want: "",
+		},
+		{
+			name: "relative link",
+			body: `<a href="/about">about</a>`,
+			want: `<a href="https://blog.example.com/about" rel="nofollow noopener noreferrer" target="_blank">about</a>`,
+		},
+		{
+			name: "relative image",
+			body: `<img src="images/cat.jpg" alt="a cat">`,
+			want: `<img src="https://blog.example.com/posts/images/cat.jpg" alt="a cat">`,
+		},
+		{
+			name: "protocol relative",
+			body: `<img src="//cdn.example.com/cat.jpg">`,
+			want: `<img src="https://cdn.example.com/cat.jpg">`,
+		},
+		{
+			name: "blockquote cite",
+			body: `<blockquote cite="../quotes/1">quote</blockquote>`,
+			want: `<blockquote cite="https://blog.example.com/quotes/1">quote</blockquote>`,
+		},
+		{
+			name: "1x1 tracking pixel",
+			body: `<p>Hi</p><img src="https://stats.example.com/p.gif" width="1" height="1">`,
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "0x0 tracking pixel in px",
+			body: `<p>Hi</p><img src="https://stats.example.com/p.gif" width="0px" height="0px">`,
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "tracker host",
+			body: `<p>Hi</p><img src="https://feeds.feedburner.com/~r/blog/~4/abc">`,
+			want: "<p>Hi</p>",
+		},
+		{
+			name: "small images that aren't pixels stay",
+			body: `<img src="https://cdn.example.com/icon.png" width="16" height="16">`,
+			want: `<img src="https://cdn.example.com/icon.png" width="16" height="16">`,
+		},
+		{
+			name: "unclosed tags get closed",
+			body: "<p>Hi <strong>there",
+			want: "<p>Hi <strong>there</strong></p>",
+		},
+		{
+			name: "stray closing tags go",
+			body: "Hi</div></p>",
+			want: "Hi",
+		},
+		{
+			name: "escaped text stays escaped",
+			body: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+			want: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+		},
+		{
+			name: "comments go",
+			body: "<p>Hi<!-- secret --></p>",
+			want: "<p>Hi</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HTML(tt.body, base)
+			if got != tt.want {
+				t.Errorf("HTML(%q) =\n%q\nwant\n%q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+// Without the post's link, there's nothing to resolve relative urls against, so they get dropped
+func TestHTMLNoBase(t *testing.T) {
+	body := `<a href="/about">about</a><img src="cat.jpg"><img src="https://cdn.example.com/cat.jpg">`
+	want := `<a rel="nofollow noopener noreferrer" target="_blank">about</a><img src="https://cdn.example.com/cat.jpg">`
+	if got := HTML(body, nil); got != want {
+		t.Errorf("HTML() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestURL(t *testing.T) {
+	base, err := url.Parse("https://podcast.example.com/episodes/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"https://cdn.example.com/1.mp3", "https://cdn.example.com/1.mp3", true},
+		{"/audio/1.mp3", "https://podcast.example.com/audio/1.mp3", true},
+		{" cover.jpg ", "https://podcast.example.com/episodes/cover.jpg", true},
+		{"", "", false},
+		{"   ", "", false},
+		{"javascript:alert(1)", "", false},
+		{"data:audio/mp3;base64,AAAA", "", false},
+		{"mailto:me@example.com", "", false},
+		{"ftp://example.com/1.mp3", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, ok := URL(tt.raw, base)
+			// When it's not ok, what comes back doesn't matter, it doesn't get used
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("URL(%q) = %q, %v, want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"plain text", "just text", "just text"},
+		{"tags go", "<p>Hello <strong>there</strong></p>", "Hello there"},
+		{"block tags separate words", "<p>one</p><p>two</p>three<br>four", "one two three four"},
+		{"inline tags don't", "un<em>believ</em>able", "unbelievable"},
+		{"entities get unescaped", "<p>Tom &amp; Jerry &lt;3</p>", "Tom & Jerry <3"},
+		{"whitespace gets squashed", "  lots \n\n of\t space  ", "lots of space"},
+		{"script and style go", "<style>p{}</style>Hi<script>alert(1)</script> there", "Hi there"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Text(tt.body); got != tt.want {
+				t.Errorf("Text(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		maxRunes int
+		want     string
+	}{
+		{"short enough", "<p>Hello there</p>", 20, "Hello there"},
+		{"exactly the limit", "Hello there", 11, "Hello there"},
+		{"cuts at a word", "<p>Hello there, general Kenobi</p>", 18, "Hello there…"},
+		{"no half words", "Hello there", 8, "Hello…"},
+		{"one long word just gets cut", "Supercalifragilistic", 5, "Super…"},
+		{"counts characters, not bytes", "héllo wörld ünïcode", 12, "héllo wörld…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Excerpt(tt.body, tt.maxRunes); got != tt.want {
+				t.Errorf("Excerpt(%q, %v) = %q, want %q", tt.body, tt.maxRunes, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// JSON Feed is basically RSS, but in json instead of xml. Some sites only publish this as application/feed+json
+// Spec is here: https://www.jsonfeed.org/version/1.1/
+// Version 1.1 renamed "author" to "authors" (a list), so we keep both to support 1.0 feeds too
+
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+type JSONFeedItem struct {
+	ID            jsonFeedID           `json:"id"`
+	URL           string               `json:"url"`
+	ExternalURL   string               `json:"external_url"`
+	Title         string               `json:"title"`
+	ContentHTML   string               `json:"content_html"`
+	ContentText   string               `json:"content_text"`
+	Summary       string               `json:"summary"`
+	DatePublished string               `json:"date_published"`
+	DateModified  string               `json:"date_modified"`
+	Author        *JSONFeedAuthor      `json:"author"`
+	Authors       []JSONFeedAuthor     `json:"authors"`
+	Tags          []string             `json:"tags"`
+	Attachments   []JSONFeedAttachment `json:"attachments"`
+}
+
+type JSONFeedAuthor struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Avatar string `json:"avatar"`
+}
+
+// Attachments are things like podcast audio files
+type JSONFeedAttachment struct {
+	URL               string  `json:"url"`
+	MimeType          string  `json:"mime_type"`
+	Title             string  `json:"title"`
+	SizeInBytes       int64   `json:"size_in_bytes"`
+	DurationInSeconds float64 `json:"duration_in_seconds"`
+}
+
+// The spec says the id is a string, but a lot of feeds just put a number there
+// If we decoded it straight into a string, the whole feed would fail to decode coz of one bad id
+// So this type accepts both, and keeps the number as its text
+type jsonFeedID string
+
+func (id *jsonFeedID) UnmarshalJSON(dat []byte) error {
+	var s string
+	if err := json.Unmarshal(dat, &s); err == nil {
+		*id = jsonFeedID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(dat, &n); err != nil {
+		return err
+	}
+	*id = jsonFeedID(n.String())
+	return nil
+}
+
+// isJSONFeed checks if the response is a JSON Feed instead of xml
+// We trust the content type when the server sends a json one, but a lot of servers send feeds as text/plain or whatever
+// so we also peek at the start of the body, an xml document can never start with {
+func isJSONFeed(contentType string, head []byte) bool {
+	if strings.Contains(strings.ToLower(contentType), "json") {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(head), []byte("{"))
+}
+
+// isValid checks the json we decoded really is a JSON Feed, and not just some other json
+// Every JSON Feed has a version like https://jsonfeed.org/version/1.1, and an items list. We're happy with either
+func (jsonFeed JSONFeed) isValid() bool {
+	version := strings.TrimPrefix(strings.TrimPrefix(jsonFeed.Version, "https://"), "http://")
+	return strings.HasPrefix(version, "jsonfeed.org/version/") || jsonFeed.Items != nil
+}
+
+// toRSSFeed converts the JSON Feed into our RSSFeed, same as we do for Atom
+func (jsonFeed JSONFeed) toRSSFeed() RSSFeed {
+	rssFeed := RSSFeed{}
+	rssFeed.Channel.Title = jsonFeed.Title
+	rssFeed.Channel.Link = jsonFeed.HomePageURL
+	rssFeed.Channel.Description = jsonFeed.Description
+
+	for _, item := range jsonFeed.Items {
+		// url is the permalink, external_url is for link-blog style posts that point to another site
+		link := item.URL
+		if link == "" {
+			link = item.ExternalURL
+		}
+
+		// A JSON Feed item has to have at least one of content_html and content_text, and can have a summary on top of that
+		description := item.Summary
+		if description == "" {
+			description = item.ContentHTML
+		}
+		if description == "" {
+			description = item.ContentText
+		}
+
+		// The full article is content_html, or content_text if there's no html version
+		content := item.ContentHTML
+		if content == "" {
+			content = item.ContentText
+		}
+
+		pubDate := item.DatePublished
+		if pubDate == "" {
+			pubDate = item.DateModified
+		}
+
+		// Attachments are the same thing as RSS enclosures
+		// There's nowhere to put a duration on an enclosure, so it goes in ITunesDuration like a podcast feed would have it
+		enclosures := []RSSEnclosure{}
+		duration := ""
+		for _, attachment := range item.Attachments {
+			length := ""
+			if attachment.SizeInBytes > 0 {
+				length = strconv.FormatInt(attachment.SizeInBytes, 10)
+			}
+			enclosures = append(enclosures, RSSEnclosure{URL: attachment.URL, Length: length, Type: attachment.MimeType})
+			if duration == "" && attachment.DurationInSeconds > 0 {
+				duration = strconv.FormatFloat(attachment.DurationInSeconds, 'f', -1, 64)
+			}
+		}
+
+		// 1.1 feeds have authors, 1.0 feeds have author
+		creators := []string{}
+		for _, author := range item.Authors {
+			creators = append(creators, author.Name)
+		}
+		if len(creators) == 0 && item.Author != nil {
+			creators = append(creators, item.Author.Name)
+		}
+
+		rssFeed.Channel.Item = append(rssFeed.Channel.Item, RSSItem{
+			Title:          strings.TrimSpace(item.Title),
+			Link:           link,
+			Description:    strings.TrimSpace(description),
+			Content:        strings.TrimSpace(content),
+			PubDate:        pubDate,
+			GUID:           strings.TrimSpace(string(item.ID)),
+			Enclosures:     enclosures,
+			ITunesDuration: duration,
+			Creators:       creators,
+			Categories:     item.Tags,
+		})
+	}
+	return rssFeed
+}
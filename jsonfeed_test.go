@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The fixtures in testdata are whole JSON Feeds, one for each version of the spec
+// They cover numeric ids, author (1.0) vs authors (1.1), attachments and items with only content_text
+func TestParseFeedJSONFeedFixtures(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		contentType string
+		want        RSSFeed
+	}{
+		{
+			name:        "1.0",
+			file:        "jsonfeed-1.0.json",
+			contentType: "application/feed+json",
+			want: jsonTestFeed("Old School Podcast", "https://podcast.example.com/", "A JSON Feed 1.0 feed",
+				RSSItem{
+					Title:       "Episode 42",
+					Link:        "https://podcast.example.com/episodes/42",
+					Description: "The answer",
+					Content:     "<p>The answer, in audio form.</p>",
+					PubDate:     "2024-03-01T10:00:00Z",
+					// A number, but we keep it as its text
+					GUID: "42",
+					Enclosures: []RSSEnclosure{
+						{URL: "https://cdn.example.com/42.mp3", Length: "31337", Type: "audio/mpeg"},
+					},
+					ITunesDuration: "1800",
+					Creators:       []string{"Jane Host"},
+					Categories:     []string{"audio", "answers"},
+				},
+				RSSItem{
+					// No url, so the external_url is the link
+					Link:        "https://elsewhere.example.com/article",
+					Description: "Just some plain text, no html version.",
+					Content:     "Just some plain text, no html version.",
+					// No date_published, so date_modified
+					PubDate:    "2024-03-02T10:00:00Z",
+					GUID:       "text-only",
+					Enclosures: []RSSEnclosure{},
+					Creators:   []string{},
+				},
+			),
+		},
+		{
+			name: "1.1",
+			file: "jsonfeed-1.1.json",
+			// Servers don't always send the right content type, so we sniff the body too
+			contentType: "text/plain; charset=utf-8",
+			want: jsonTestFeed("New School Blog", "https://blog.example.com/", "",
+				RSSItem{
+					Title:       "Hello JSON Feed",
+					Link:        "https://blog.example.com/posts/1",
+					Description: "<p>Full post</p>",
+					Content:     "<p>Full post</p>",
+					PubDate:     "2024-04-01T08:30:00+02:00",
+					GUID:        "https://blog.example.com/posts/1",
+					Enclosures:  []RSSEnclosure{},
+					// authors wins over the old author field
+					Creators: []string{"Alice", "Bob"},
+				},
+				RSSItem{
+					Title:       "Numbered",
+					Link:        "https://blog.example.com/posts/7",
+					Description: "Only text here",
+					Content:     "Only text here",
+					GUID:        "7",
+					Enclosures: []RSSEnclosure{
+						{URL: "https://cdn.example.com/7.pdf", Type: "application/pdf"},
+						{URL: "https://cdn.example.com/7.mp4", Length: "1024", Type: "video/mp4"},
+					},
+					// The first attachment that has a duration
+					ITunesDuration: "61.5",
+					Creators:       []string{},
+				},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			got, err := parseFeed(file, tt.contentType)
+			if err != nil {
+				t.Fatalf("parseFeed() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFeed() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONFeedToRSSFeed(t *testing.T) {
+	tests := []struct {
+		name string
+		item JSONFeedItem
+		want RSSItem
+	}{
+		{
+			name: "summary is the description, content_html is the content",
+			item: JSONFeedItem{ID: "a", Summary: "short", ContentHTML: "<p>long</p>", ContentText: "long"},
+			want: RSSItem{GUID: "a", Description: "short", Content: "<p>long</p>", Enclosures: []RSSEnclosure{}, Creators: []string{}},
+		},
+		{
+			name: "content_text only",
+			item: JSONFeedItem{ID: "b", ContentText: "just text"},
+			want: RSSItem{GUID: "b", Description: "just text", Content: "just text", Enclosures: []RSSEnclosure{}, Creators: []string{}},
+		},
+		{
+			name: "url wins over external_url",
+			item: JSONFeedItem{ID: "c", URL: "https://a.example.com", ExternalURL: "https://b.example.com"},
+			want: RSSItem{GUID: "c", Link: "https://a.example.com", Enclosures: []RSSEnclosure{}, Creators: []string{}},
+		},
+		{
+			name: "date_published wins over date_modified",
+			item: JSONFeedItem{ID: "d", DatePublished: "2024-01-01T00:00:00Z", DateModified: "2024-02-01T00:00:00Z"},
+			want: RSSItem{GUID: "d", PubDate: "2024-01-01T00:00:00Z", Enclosures: []RSSEnclosure{}, Creators: []string{}},
+		},
+		{
+			name: "1.0 author",
+			item: JSONFeedItem{ID: "e", Author: &JSONFeedAuthor{Name: "Jane"}},
+			want: RSSItem{GUID: "e", Enclosures: []RSSEnclosure{}, Creators: []string{"Jane"}},
+		},
+		{
+			name: "1.1 authors win over author",
+			item: JSONFeedItem{ID: "f", Author: &JSONFeedAuthor{Name: "Jane"}, Authors: []JSONFeedAuthor{{Name: "Alice"}, {Name: "Bob"}}},
+			want: RSSItem{GUID: "f", Enclosures: []RSSEnclosure{}, Creators: []string{"Alice", "Bob"}},
+		},
+		{
+			name: "attachment without size or duration",
+			item: JSONFeedItem{ID: "g", Attachments: []JSONFeedAttachment{{URL: "https://cdn.example.com/a.mp3", MimeType: "audio/mpeg"}}},
+			want: RSSItem{
+				GUID:       "g",
+				Enclosures: []RSSEnclosure{{URL: "https://cdn.example.com/a.mp3", Type: "audio/mpeg"}},
+				Creators:   []string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := JSONFeed{Items: []JSONFeedItem{tt.item}}.toRSSFeed()
+			if len(got.Channel.Item) != 1 {
+				t.Fatalf("toRSSFeed() gave %v items, want 1", len(got.Channel.Item))
+			}
+			if !reflect.DeepEqual(got.Channel.Item[0], tt.want) {
+				t.Errorf("toRSSFeed() item =\n%+v\nwant\n%+v", got.Channel.Item[0], tt.want)
+			}
+		})
+	}
+}
+
+// jsonTestFeed builds the RSSFeed we expect toRSSFeed to give back
+func jsonTestFeed(title, link, description string, items ...RSSItem) RSSFeed {
+	feed := RSSFeed{}
+	feed.Channel.Title = title
+	feed.Channel.Link = link
+	feed.Channel.Description = description
+	feed.Channel.Item = items
+	return feed
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Yendelevium/RSSAggregator/internal/database"
+	"github.com/Yendelevium/RSSAggregator/internal/netguard"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/cors"
 	"github.com/joho/godotenv"
@@ -19,10 +25,15 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// How long we give in-flight HTTP requests to finish when the server is shutting down
+const shutdownTimeout = 30 * time.Second
+
 // This struct holds a connectionn to a database
 // The database.Queries type was actually created by sqlc in the database folder
 type apiConfig struct {
 	DB *database.Queries
+	// The one http client we use for fetching feeds, shared with the scraper (see fetcher.go)
+	Fetcher *feedFetcher
 }
 
 func main() {
@@ -49,6 +60,48 @@ func main() {
 		log.Fatal("Error: DB_URL not found in the environment")
 	}
 
+	// How many times in a row a feed can fail to fetch before the scraper disables it
+	// This one's optional, so if it's not in the env we just go with 10
+	maxFailures := int(envPositiveInt("FEED_MAX_FAILURES", 10))
+
+	// The scraper works out how often to fetch each feed, but never more often than FEED_MIN_INTERVAL
+	// or less often than FEED_MAX_INTERVAL. These are go durations, like "15m" or "24h"
+	minInterval := envDuration("FEED_MIN_INTERVAL", 15*time.Minute)
+	maxInterval := envDuration("FEED_MAX_INTERVAL", 24*time.Hour)
+	if minInterval > maxInterval {
+		log.Fatal("Error: FEED_MIN_INTERVAL can't be more than FEED_MAX_INTERVAL")
+	}
+
+	// How many fetch attempts we keep in feed_fetch_log for each feed, the older ones get deleted
+	// GET /v1/feeds/{feedID}/fetches only shows the newest 50, so the default of 100 is plenty
+	fetchLogKeep := int(envPositiveInt("FEED_FETCH_LOG_KEEP", 100))
+
+	// The biggest feed we'll download, so a feed url pointing at some huge file can't use up all our memory
+	// This one's optional too, it's 10MB if it's not in the env
+	maxBodyBytes := envPositiveInt("FEED_MAX_BODY_BYTES", 10<<20)
+
+	// Feeds are fetched from inside our network, so by default we refuse to fetch private addresses like localhost
+	// FEED_ALLOWED_HOSTS is a comma separated list of hostnames, IPs and CIDR ranges that are allowed anyway,
+	// for people who host their own feeds on the same network
+	guard, err := netguard.New(strings.Split(os.Getenv("FEED_ALLOWED_HOSTS"), ","))
+	if err != nil {
+		log.Fatal("Error: FEED_ALLOWED_HOSTS isn't valid:", err)
+	}
+
+	// How we fetch feeds. FEED_FETCH_TIMEOUT is how long one fetch can take,
+	// FEED_CONTACT_URL goes in our User-Agent so site owners know who we are,
+	// and FEED_PROXY_URL sends all the fetches through a proxy (HTTP_PROXY and HTTPS_PROXY are ignored, see fetcher.go)
+	fetcher, err := newFeedFetcher(feedFetcherConfig{
+		timeout:      envDuration("FEED_FETCH_TIMEOUT", 10*time.Second),
+		contactURL:   envString("FEED_CONTACT_URL", "https://github.com/Yendelevium/RSSAggregator"),
+		proxyURL:     os.Getenv("FEED_PROXY_URL"),
+		maxBodyBytes: maxBodyBytes,
+		guard:        guard,
+	})
+	if err != nil {
+		log.Fatal("Error: FEED_PROXY_URL isn't valid:", err)
+	}
+
 	// The go standard library has a build in sql package
 	// We connect to the database using sql.Open("driver name", connectionstring)
 	// This returns a new connection and an error
@@ -65,13 +118,29 @@ func main() {
 		// a pointer to the database.Queries type. See the sqlc files to get more understanding
 		// The DB is a database.Queries, but our connection is an sql.DB, so we r gonna convert it
 		// to a database.Queries using the database.New() function
-		DB: db,
+		DB:      db,
+		Fetcher: fetcher,
 	}
 
+	// This context gets cancelled when the process gets a SIGINT (ctrl+c) or a SIGTERM (what a deploy sends)
+	// Everything long running hangs off of it, so they all know when to wrap up
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Now, we have to hookup the scraper so it starts scraping
 	// We have to call it before and ListenandServe as that's where our function kindof blocks forever and waits for requests
-	// Let's just get 10 posts, every minute for now
-	go startScraping(db, 10, time.Minute)
+	// It keeps 10 workers busy with feeds that are due, checking for more every minute when none are, and each feed decides how often it's due
+	// scraperDone gets closed once the scraper has finished its last batch, so we know when it's safe to close the db
+	scraperDone := make(chan struct{})
+	go func() {
+		startScraping(ctx, conn, apiCfg.Fetcher, 10, time.Minute, scrapeConfig{
+			maxFailures:  maxFailures,
+			minInterval:  minInterval,
+			maxInterval:  maxInterval,
+			fetchLogKeep: fetchLogKeep,
+		})
+		close(scraperDone)
+	}()
 
 	// This creates a new router object
 	router := chi.NewRouter()
@@ -112,10 +181,20 @@ func main() {
 	// U also have to pass the Authorization header, as u need that fr authing the user whos creating the feed
 	v1Router.Post("/feeds", apiCfg.middlewareAuth(apiCfg.handlerCreateFeed))
 
+	// Fetches a feed url and tells u its title, description and how many items it has, without saving anything
+	v1Router.Post("/feeds/preview", apiCfg.middlewareAuth(apiCfg.handlerPreviewFeed))
+
 	// This let's any user to get all of the feeds in our database
 	// This is not an authenticated endpoint, so no need fr the Auth header, or to call the middleware func
 	// As the function is already a http.HandlerFuncs
 	v1Router.Get("/feeds", apiCfg.handlerGetFeeds)
+
+	// The history of the scraper's attempts at fetching a feed, so u can see why a feed isn't getting new posts
+	v1Router.Get("/feeds/{feedID}/fetches", apiCfg.handlerGetFeedFetches)
+
+	// Turns a feed back on after the scraper disabled it for failing too much. Only the feed's owner can do this
+	v1Router.Post("/feeds/{feedID}/enable", apiCfg.middlewareAuth(apiCfg.handlerEnableFeed))
+
 	v1Router.Post("/feed_follows", apiCfg.middlewareAuth(apiCfg.handlerCreateFeedFollow))
 	v1Router.Get("/feed_follows", apiCfg.middlewareAuth(apiCfg.handlerGetFeedFollows))
 
@@ -145,9 +224,75 @@ func main() {
 	}
 	log.Printf("Server starting on port %v", portString)
 	// ListenAndServe() blocks. It's now listening to http requests on the server
-	// If anything goes wrong, it returns an error, and we will log that error and exit that program
-	err = srv.ListenAndServe()
+	// We run it in its own goroutine, so that main can wait for a shutdown signal at the same time
+	// If anything goes wrong, it returns an error, which we get through the channel
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		// ListenAndServe only returns by itself if something went wrong, like the port already being used
+		// We still shut the scraper down properly before we exit
+		log.Println("Server error:", err)
+		stop()
+	case <-ctx.Done():
+		log.Println("Shutdown signal received, shutting down")
+	}
+
+	// Shutdown stops accepting new requests, and waits for the ones in flight to finish
+	// We don't wait forever though, if requests are still going after shutdownTimeout, we give up on them
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+		log.Println("Error shutting down server:", shutdownErr)
+	}
+
+	// Cancelling ctx told the scraper to stop, now we wait for it to finish saving its current batch
+	<-scraperDone
+
+	// Nothing is using the db anymore, so we can close the connection pool
+	if closeErr := conn.Close(); closeErr != nil {
+		log.Println("Error closing database:", closeErr)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Println("Server stopped")
+}
+
+// envString reads an optional string from the environment, and falls back on def when it isn't set
+func envString(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
+
+// envPositiveInt reads an optional positive number from the environment, and falls back on def when it isn't set
+// It's an int64 coz of FEED_MAX_BODY_BYTES, the others just convert it to an int
+func envPositiveInt(key string, def int64) int64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 1 {
+		log.Fatalf("Error: %v must be a positive number", key)
+	}
+	return n
+}
+
+// envDuration reads an optional duration from the environment, and falls back on def when it isn't set
+func envDuration(key string, def time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		log.Fatalf("Error: %v must be a positive duration, like 15m", key)
+	}
+	return d
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"database/sql"
+	"strings"
 	"time"
 
 	"github.com/Yendelevium/RSSAggregator/internal/database"
@@ -35,16 +37,40 @@ type Feed struct {
 	Name      string    `json:"name"`
 	Url       string    `json:"url"`
 	UserID    uuid.UUID `json:"user_id"`
+	// These tell u if the scraper is having trouble with the feed. last_error is null if the last fetch worked
+	LastError           *string `json:"last_error"`
+	ConsecutiveFailures int32   `json:"consecutive_failures"`
+	// When the scraper will try the feed next, null means as soon as it gets to it
+	NextFetchAt *time.Time `json:"next_fetch_at"`
+	// Set when the feed failed too many times in a row. The scraper skips it until the owner re-enables it
+	DisabledAt *time.Time `json:"disabled_at"`
 }
 
 func databaseFeedtoFeed(dbFeed database.Feed) Feed {
+	var lastError *string
+	if dbFeed.LastError.Valid {
+		lastError = &dbFeed.LastError.String
+	}
+	var nextFetchAt *time.Time
+	if dbFeed.NextFetchAt.Valid {
+		nextFetchAt = &dbFeed.NextFetchAt.Time
+	}
+	var disabledAt *time.Time
+	if dbFeed.DisabledAt.Valid {
+		disabledAt = &dbFeed.DisabledAt.Time
+	}
+
 	return Feed{
-		ID:        dbFeed.ID,
-		CreatedAt: dbFeed.CreatedAt,
-		UpdateAt:  dbFeed.UpdateAt,
-		Name:      dbFeed.Name,
-		Url:       dbFeed.Url,
-		UserID:    dbFeed.UserID,
+		ID:                  dbFeed.ID,
+		CreatedAt:           dbFeed.CreatedAt,
+		UpdateAt:            dbFeed.UpdateAt,
+		Name:                dbFeed.Name,
+		Url:                 dbFeed.Url,
+		UserID:              dbFeed.UserID,
+		LastError:           lastError,
+		ConsecutiveFailures: dbFeed.ConsecutiveFailures,
+		NextFetchAt:         nextFetchAt,
+		DisabledAt:          disabledAt,
 	}
 }
 
@@ -57,6 +83,83 @@ func databaseFeedstoFeeds(dbFeeds []database.Feed) []Feed {
 	return feeds
 }
 
+// FeedPreview is what we found at a feed url, without saving anything
+// It's what POST /feeds/preview returns, so u can check a url is really a feed before adding it
+type FeedPreview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ItemCount   int    `json:"item_count"`
+}
+
+func rssFeedToFeedPreview(url string, rssFeed RSSFeed) FeedPreview {
+	return FeedPreview{
+		Url:         url,
+		Title:       strings.TrimSpace(rssFeed.Channel.Title),
+		Description: strings.TrimSpace(rssFeed.Channel.Description),
+		ItemCount:   len(rssFeed.Channel.Item),
+	}
+}
+
+// FeedCandidate is a feed we found linked from a web page, see discovery.go
+type FeedCandidate struct {
+	Url   string `json:"url"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+// One attempt of the scraper to fetch a feed
+// status_code is null if we never got a response, and error is null if the fetch worked
+type FeedFetch struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	FeedID       uuid.UUID `json:"feed_id"`
+	StatusCode   *int32    `json:"status_code"`
+	DurationMs   int32     `json:"duration_ms"`
+	Bytes        int64     `json:"bytes"`
+	ItemCount    int32     `json:"item_count"`
+	NewPostCount int32     `json:"new_post_count"`
+	Error        *string   `json:"error"`
+	// The url the feed had before this fetch, if this fetch is when we found out it moved
+	MovedFrom *string `json:"moved_from"`
+}
+
+func databaseFeedFetchLogToFeedFetch(dbFetch database.FeedFetchLog) FeedFetch {
+	var statusCode *int32
+	if dbFetch.StatusCode.Valid {
+		statusCode = &dbFetch.StatusCode.Int32
+	}
+	var fetchErr *string
+	if dbFetch.Error.Valid {
+		fetchErr = &dbFetch.Error.String
+	}
+	var movedFrom *string
+	if dbFetch.MovedFrom.Valid {
+		movedFrom = &dbFetch.MovedFrom.String
+	}
+
+	return FeedFetch{
+		ID:           dbFetch.ID,
+		CreatedAt:    dbFetch.CreatedAt,
+		FeedID:       dbFetch.FeedID,
+		StatusCode:   statusCode,
+		DurationMs:   dbFetch.DurationMs,
+		Bytes:        dbFetch.Bytes,
+		ItemCount:    dbFetch.ItemCount,
+		NewPostCount: dbFetch.NewPostCount,
+		Error:        fetchErr,
+		MovedFrom:    movedFrom,
+	}
+}
+
+func databaseFeedFetchLogsToFeedFetches(dbFetches []database.FeedFetchLog) []FeedFetch {
+	fetches := []FeedFetch{}
+	for _, dbFetch := range dbFetches {
+		fetches = append(fetches, databaseFeedFetchLogToFeedFetch(dbFetch))
+	}
+	return fetches
+}
+
 type FeedFollow struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -102,23 +205,142 @@ type Post struct {
 	PublishedAt time.Time `json:"published_at"`
 	Url         string    `json:"url"`
 	FeedID      uuid.UUID `json:"feed_id"`
+	// summary is the same as description. description is still there so clients from before keep working
+	// Both are the sanitized html, never the raw html from the feed
+	Summary *string `json:"summary"`
+	// The full article (sanitized too), if the feed has it. It can be big, so it's left out entirely when u ask for omit_content
+	Content *string `json:"content,omitempty"`
+	// A short plain text version of the post, for previews
+	Excerpt *string `json:"excerpt"`
+	// true when the feed didn't give us a date we could parse, and published_at is just when we first fetched the post
+	PublishedAtInferred bool `json:"published_at_inferred"`
+	// Starts at 1, and goes up every time the source edits the post. Anything above 1 means the post was updated
+	Revision int32 `json:"revision"`
+	// Files attached to the post, like a podcast episode's audio. Always a list, empty if there aren't any
+	Enclosures []Enclosure `json:"enclosures"`
+	// The names of the post's authors and its categories (or tags). Always lists too
+	Authors    []string `json:"authors"`
+	Categories []string `json:"categories"`
 }
 
 func databasePostToPost(dbPost database.Post) Post {
+	// Posts saved before we sanitized them don't have the sanitized columns, so we clean them up here instead
+	if !dbPost.Excerpt.Valid && (dbPost.Description.Valid || dbPost.Content.Valid) {
+		sanitized := sanitizePost(dbPost.Url, dbPost.Description.String, dbPost.Content.String)
+		dbPost.DescriptionSanitized = sql.NullString{String: sanitized.Description, Valid: dbPost.Description.Valid}
+		dbPost.ContentSanitized = sql.NullString{String: sanitized.Content, Valid: dbPost.Content.Valid}
+		dbPost.Excerpt = sql.NullString{String: sanitized.Excerpt, Valid: sanitized.Excerpt != ""}
+	}
+
+	// We only ever give out the sanitized html
 	var description *string
-	if dbPost.Description.Valid {
-		description = &dbPost.Description.String
+	if dbPost.DescriptionSanitized.Valid {
+		description = &dbPost.DescriptionSanitized.String
+	}
+	var content *string
+	if dbPost.ContentSanitized.Valid {
+		content = &dbPost.ContentSanitized.String
+	}
+	var excerpt *string
+	if dbPost.Excerpt.Valid {
+		excerpt = &dbPost.Excerpt.String
 	}
 
 	return Post{
-		ID:          dbPost.ID,
-		CreatedAt:   dbPost.CreatedAt,
-		UpdateAt:    dbPost.UpdateAt,
-		Title:       dbPost.Title,
-		Description: description,
-		PublishedAt: dbPost.PublishedAt,
-		Url:         dbPost.Url,
-		FeedID:      dbPost.FeedID,
+		ID:                  dbPost.ID,
+		CreatedAt:           dbPost.CreatedAt,
+		UpdateAt:            dbPost.UpdateAt,
+		Title:               dbPost.Title,
+		Description:         description,
+		PublishedAt:         dbPost.PublishedAt,
+		Url:                 dbPost.Url,
+		FeedID:              dbPost.FeedID,
+		Summary:             description,
+		Content:             content,
+		Excerpt:             excerpt,
+		PublishedAtInferred: dbPost.PublishedAtInferred,
+		Revision:            dbPost.Revision,
+		Enclosures:          []Enclosure{},
+		Authors:             []string{},
+		Categories:          []string{},
+	}
+}
+
+// A file attached to a post, from an RSS <enclosure>, an Atom enclosure link, a JSON Feed attachment or Media RSS
+// kind is enclosure, media_content or media_thumbnail. Everything that can be null is null when the feed didn't say
+type Enclosure struct {
+	Kind            string  `json:"kind"`
+	Url             string  `json:"url"`
+	MimeType        *string `json:"mime_type"`
+	Length          *int64  `json:"length"`
+	DurationSeconds *int32  `json:"duration_seconds"`
+	ImageUrl        *string `json:"image_url"`
+	Episode         *int32  `json:"episode"`
+	Season          *int32  `json:"season"`
+}
+
+func databasePostEnclosureToEnclosure(dbEnclosure database.PostEnclosure) Enclosure {
+	enclosure := Enclosure{
+		Kind: dbEnclosure.Kind,
+		Url:  dbEnclosure.Url,
+	}
+	if dbEnclosure.MimeType.Valid {
+		enclosure.MimeType = &dbEnclosure.MimeType.String
+	}
+	if dbEnclosure.Length.Valid {
+		enclosure.Length = &dbEnclosure.Length.Int64
+	}
+	if dbEnclosure.DurationSeconds.Valid {
+		enclosure.DurationSeconds = &dbEnclosure.DurationSeconds.Int32
+	}
+	if dbEnclosure.ImageUrl.Valid {
+		enclosure.ImageUrl = &dbEnclosure.ImageUrl.String
+	}
+	if dbEnclosure.Episode.Valid {
+		enclosure.Episode = &dbEnclosure.Episode.Int32
+	}
+	if dbEnclosure.Season.Valid {
+		enclosure.Season = &dbEnclosure.Season.Int32
+	}
+	return enclosure
+}
+
+// attachEnclosures puts each enclosure on the post it belongs to
+func attachEnclosures(posts []Post, dbEnclosures []database.PostEnclosure) {
+	byPost := map[uuid.UUID][]Enclosure{}
+	for _, dbEnclosure := range dbEnclosures {
+		byPost[dbEnclosure.PostID] = append(byPost[dbEnclosure.PostID], databasePostEnclosureToEnclosure(dbEnclosure))
+	}
+	for i := range posts {
+		if enclosures, ok := byPost[posts[i].ID]; ok {
+			posts[i].Enclosures = enclosures
+		}
+	}
+}
+
+// attachAuthors puts each author on the post they wrote
+func attachAuthors(posts []Post, rows []database.GetAuthorsForPostsRow) {
+	byPost := map[uuid.UUID][]string{}
+	for _, row := range rows {
+		byPost[row.PostID] = append(byPost[row.PostID], row.Name)
+	}
+	for i := range posts {
+		if authors, ok := byPost[posts[i].ID]; ok {
+			posts[i].Authors = authors
+		}
+	}
+}
+
+// attachCategories puts each category on the post it belongs to
+func attachCategories(posts []Post, rows []database.GetCategoriesForPostsRow) {
+	byPost := map[uuid.UUID][]string{}
+	for _, row := range rows {
+		byPost[row.PostID] = append(byPost[row.PostID], row.Name)
+	}
+	for i := range posts {
+		if categories, ok := byPost[posts[i].ID]; ok {
+			posts[i].Categories = categories
+		}
 	}
 }
 
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yendelevium/RSSAggregator/internal/database"
+	"github.com/Yendelevium/RSSAggregator/internal/sanitize"
+	"github.com/google/uuid"
+)
+
+// Podcasts are just RSS feeds where every item has an <enclosure>, which is the audio file
+// On top of that, most of them use the itunes: namespace for stuff like how long the episode is, and its cover art
+// Video and photo feeds use Media RSS (the media: namespace) instead, with <media:content> and <media:thumbnail>
+// Without these, episodes just showed up as bare links
+// See https://www.rssboard.org/rss-specification#ltenclosuregtSubelementOfLtitemgt
+// and https://help.apple.com/itc/podcasts_connect/#/itcb54353390 for the itunes tags
+
+// <enclosure url="..." length="12345" type="audio/mpeg"/>
+// length is in bytes. Like the other hints, it's a string so a broken one doesn't break the whole feed
+type RSSEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// <itunes:image href="..."/>
+type ITunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// <media:content url="..." type="video/mp4" fileSize="12345" duration="60"/>
+type MediaContent struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	FileSize string `xml:"fileSize,attr"`
+	Duration string `xml:"duration,attr"`
+}
+
+// <media:thumbnail url="..."/>
+type MediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+// The kinds of enclosure we save, so clients can tell an episode from a thumbnail
+const (
+	enclosureKindEnclosure      = "enclosure"
+	enclosureKindMediaContent   = "media_content"
+	enclosureKindMediaThumbnail = "media_thumbnail"
+)
+
+// postEnclosure is one file attached to a post, from any of the tags above
+// The numbers are 0 when the feed didn't say, which end up NULL in the db
+type postEnclosure struct {
+	Kind            string
+	URL             string
+	MimeType        string
+	Length          int64
+	DurationSeconds int32
+	ImageURL        string
+	Episode         int32
+	Season          int32
+}
+
+// enclosures collects everything attached to an item into a list of postEnclosures
+// The itunes tags are about the whole episode, so every enclosure of the item gets them
+// If the same url shows up more than once (a lot of podcasts have it as both <enclosure> and <media:content>), we only keep the first
+func (item RSSItem) enclosures() []postEnclosure {
+	// The urls get handed to clients to play or show, so they go through the same check as the images in the post body:
+	// relative ones are resolved against the item's link, and anything that isn't http(s) (like javascript:) is dropped
+	base := postBaseURL(item.Link)
+	duration := parseITunesDuration(item.ITunesDuration)
+	imageURL, ok := sanitize.URL(item.ITunesImage.Href, base)
+	if !ok {
+		imageURL = ""
+	}
+	episode := parsePositiveInt32(item.ITunesEpisode)
+	season := parsePositiveInt32(item.ITunesSeason)
+
+	enclosures := []postEnclosure{}
+	seen := map[string]bool{}
+	add := func(enclosure postEnclosure) {
+		resolved, ok := sanitize.URL(enclosure.URL, base)
+		if !ok || seen[resolved] {
+			return
+		}
+		enclosure.URL = resolved
+		seen[enclosure.URL] = true
+		enclosures = append(enclosures, enclosure)
+	}
+
+	for _, enclosure := range item.Enclosures {
+		add(postEnclosure{
+			Kind:            enclosureKindEnclosure,
+			URL:             enclosure.URL,
+			MimeType:        strings.TrimSpace(enclosure.Type),
+			Length:          parseLength(enclosure.Length),
+			DurationSeconds: duration,
+			ImageURL:        imageURL,
+			Episode:         episode,
+			Season:          season,
+		})
+	}
+	for _, content := range item.MediaContent {
+		// media:content has its own duration, but plenty of feeds only put it in itunes:duration
+		contentDuration := parseITunesDuration(content.Duration)
+		if contentDuration == 0 {
+			contentDuration = duration
+		}
+		add(postEnclosure{
+			Kind:            enclosureKindMediaContent,
+			URL:             content.URL,
+			MimeType:        strings.TrimSpace(content.Type),
+			Length:          parseLength(content.FileSize),
+			DurationSeconds: contentDuration,
+			ImageURL:        imageURL,
+			Episode:         episode,
+			Season:          season,
+		})
+	}
+	for _, thumbnail := range item.MediaThumbnails {
+		add(postEnclosure{
+			Kind: enclosureKindMediaThumbnail,
+			URL:  thumbnail.URL,
+		})
+	}
+	return enclosures
+}
+
+// parseITunesDuration turns an itunes:duration into seconds
+// It can be just seconds ("3600"), or "MM:SS" or "HH:MM:SS". Returns 0 if we can't make sense of it
+func parseITunesDuration(duration string) int32 {
+	duration = strings.TrimSpace(duration)
+	if duration == "" {
+		return 0
+	}
+	parts := strings.Split(duration, ":")
+	if len(parts) > 3 {
+		return 0
+	}
+	seconds := 0.0
+	for _, part := range parts {
+		// Some feeds have fractions of a second, like 123.5
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	// Nothing's 68 years long, this just keeps it from overflowing
+	if seconds > float64(1<<31-1) {
+		return 0
+	}
+	return int32(seconds)
+}
+
+// parseLength parses an enclosure length, returning 0 if it isn't a positive number
+func parseLength(length string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(length), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// parsePositiveInt32 is for itunes:episode and itunes:season, returning 0 if it isn't a positive number
+func parsePositiveInt32(s string) int32 {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return int32(n)
+}
+
+// savePostEnclosures saves the enclosures of a post, and deletes the ones the feed doesn't have anymore
+func savePostEnclosures(ctx context.Context, db *database.Queries, postID uuid.UUID, enclosures []postEnclosure) error {
+	urls := []string{}
+	for _, enclosure := range enclosures {
+		err := db.UpsertPostEnclosure(ctx, database.UpsertPostEnclosureParams{
+			ID:              uuid.New(),
+			CreatedAt:       time.Now().UTC(),
+			PostID:          postID,
+			Kind:            enclosure.Kind,
+			Url:             enclosure.URL,
+			MimeType:        sql.NullString{String: enclosure.MimeType, Valid: enclosure.MimeType != ""},
+			Length:          sql.NullInt64{Int64: enclosure.Length, Valid: enclosure.Length > 0},
+			DurationSeconds: sql.NullInt32{Int32: enclosure.DurationSeconds, Valid: enclosure.DurationSeconds > 0},
+			ImageUrl:        sql.NullString{String: enclosure.ImageURL, Valid: enclosure.ImageURL != ""},
+			Episode:         sql.NullInt32{Int32: enclosure.Episode, Valid: enclosure.Episode > 0},
+			Season:          sql.NullInt32{Int32: enclosure.Season, Valid: enclosure.Season > 0},
+		})
+		if err != nil {
+			return err
+		}
+		urls = append(urls, enclosure.URL)
+	}
+	return db.DeleteStalePostEnclosures(ctx, database.DeleteStalePostEnclosuresParams{
+		PostID: postID,
+		Urls:   urls,
+	})
+}
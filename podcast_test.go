@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestItemEnclosuresURLs(t *testing.T) {
+	tests := []struct {
+		name      string
+		item      RSSItem
+		wantURLs  []string
+		wantImage string
+	}{
+		{
+			name: "relative urls are resolved against the link",
+			item: RSSItem{
+				Link:        "https://podcast.example.com/episodes/1",
+				Enclosures:  []RSSEnclosure{{URL: "/audio/1.mp3"}},
+				ITunesImage: ITunesImage{Href: "cover.jpg"},
+			},
+			wantURLs:  []string{"https://podcast.example.com/audio/1.mp3"},
+			wantImage: "https://podcast.example.com/episodes/cover.jpg",
+		},
+		{
+			name: "only http(s) is allowed",
+			item: RSSItem{
+				Link: "https://podcast.example.com/episodes/1",
+				Enclosures: []RSSEnclosure{
+					{URL: "javascript:alert(1)"},
+					{URL: "data:audio/mpeg;base64,AAAA"},
+					{URL: "ftp://podcast.example.com/1.mp3"},
+					{URL: " https://cdn.example.com/1.mp3 "},
+				},
+				MediaThumbnails: []MediaThumbnail{{URL: "mailto:someone@example.com"}},
+				ITunesImage:     ITunesImage{Href: "javascript:alert(1)"},
+			},
+			wantURLs: []string{"https://cdn.example.com/1.mp3"},
+		},
+		{
+			name: "relative urls are dropped when the link isn't absolute",
+			item: RSSItem{
+				Enclosures:   []RSSEnclosure{{URL: "/audio/1.mp3"}},
+				MediaContent: []MediaContent{{URL: "https://cdn.example.com/1.mp4"}},
+				ITunesImage:  ITunesImage{Href: "cover.jpg"},
+			},
+			wantURLs: []string{"https://cdn.example.com/1.mp4"},
+		},
+		{
+			name: "empty urls aren't the link",
+			item: RSSItem{
+				Link:       "https://podcast.example.com/episodes/1",
+				Enclosures: []RSSEnclosure{{URL: ""}, {URL: "  "}},
+			},
+			wantURLs: []string{},
+		},
+		{
+			name: "the same url once it's resolved is only kept once",
+			item: RSSItem{
+				Link:         "https://podcast.example.com/episodes/1",
+				Enclosures:   []RSSEnclosure{{URL: "/audio/1.mp3"}},
+				MediaContent: []MediaContent{{URL: "https://podcast.example.com/audio/1.mp3"}},
+			},
+			wantURLs: []string{"https://podcast.example.com/audio/1.mp3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.item.enclosures()
+			urls := []string{}
+			for _, enclosure := range got {
+				urls = append(urls, enclosure.URL)
+				if enclosure.Kind != enclosureKindMediaThumbnail && enclosure.ImageURL != tt.wantImage {
+					t.Errorf("enclosures() image = %q, want %q", enclosure.ImageURL, tt.wantImage)
+				}
+			}
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("enclosures() urls = %q, want %q", urls, tt.wantURLs)
+			}
+		})
+	}
+}
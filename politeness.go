@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A lot of our feeds live on the same few hosts (substack, medium, github etc), and with a bunch of workers
+// the scraper could hit one of them with a bunch of requests at the same time. That's rude, and it gets us rate limited
+// So every request goes through hostLimiter first, which makes sure that for each host:
+// - only hostMaxConcurrent requests are going at once
+// - requests start at least hostMinDelay apart
+// - if the host told us to back off with Retry-After, we don't send it anything till then
+
+const (
+	hostMaxConcurrent = 2
+	hostMinDelay      = time.Second
+)
+
+type hostLimiter struct {
+	maxConcurrent int
+	minDelay      time.Duration
+
+	// mu protects hosts, and everything in the hostStates except slots (which is a channel, so it's already safe)
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	// A request puts a value in here before it starts, and takes it out when it's done
+	// It's buffered with room for maxConcurrent, so that's how many requests can be going at once
+	slots chan struct{}
+	// The earliest the next request to this host is allowed to start
+	nextRequest time.Time
+	// If the host sent us a Retry-After, we don't send it anything before this
+	retryAt time.Time
+}
+
+func newHostLimiter(maxConcurrent int, minDelay time.Duration) *hostLimiter {
+	return &hostLimiter{
+		maxConcurrent: maxConcurrent,
+		minDelay:      minDelay,
+		hosts:         map[string]*hostState{},
+	}
+}
+
+// state gets the hostState for a host, making it if this is the first time we see the host
+func (l *hostLimiter) state(host string) *hostState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hs, ok := l.hosts[host]
+	if !ok {
+		hs = &hostState{slots: make(chan struct{}, l.maxConcurrent)}
+		l.hosts[host] = hs
+	}
+	return hs
+}
+
+// acquire waits till we're allowed to send a request to host, and returns a func to call once the request is done
+// If the host asked us to back off, we don't wait for that (it could be hours), we return a retryAfterError straight away
+// so the scraper can move the feed's next fetch out instead of tying up a worker
+func (l *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	host = strings.ToLower(host)
+	hs := l.state(host)
+
+	l.mu.Lock()
+	retryAt := hs.retryAt
+	l.mu.Unlock()
+	if wait := time.Until(retryAt); wait > 0 {
+		return nil, &retryAfterError{retryAfter: wait}
+	}
+
+	// Wait for a free slot
+	select {
+	case hs.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-hs.slots }
+
+	// Book our start time, so the next request knows to go minDelay after us
+	l.mu.Lock()
+	start := time.Now()
+	if hs.nextRequest.After(start) {
+		start = hs.nextRequest
+	}
+	hs.nextRequest = start.Add(l.minDelay)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return release, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// backOff makes us leave host alone till until
+func (l *hostLimiter) backOff(host string, until time.Time) {
+	hs := l.state(strings.ToLower(host))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(hs.retryAt) {
+		hs.retryAt = until
+	}
+}
+
+// retryAfterError is what urlToFeed returns when the server says slow down (429 or 503) and tells us for how long
+// The scraper checks for it with errors.As, and postpones the feed instead of counting it as a failure
+type retryAfterError struct {
+	// 0 when it came from the limiter, and not from a response
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	if e.statusCode == 0 {
+		return fmt.Sprintf("host asked us to back off, retry after %s", e.retryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("unexpected status code %v, retry after %s", e.statusCode, e.retryAfter.Round(time.Second))
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or an HTTP date
+// It returns false if the header is missing or broken
+// We never wait longer than backoffMax, whatever the server says
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		// Checking this first, so a giant number can't overflow the duration
+		if seconds > int(backoffMax/time.Second) {
+			return backoffMax, true
+		}
+		wait = time.Duration(seconds) * time.Second
+	} else {
+		at, err := http.ParseTime(header)
+		if err != nil {
+			return 0, false
+		}
+		wait = at.Sub(now)
+	}
+	return min(max(wait, 0), backoffMax), true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/Yendelevium/RSSAggregator/internal/sanitize"
+)
+
+// How long the plain text excerpt of a post can be, in characters
+const excerptLength = 300
+
+// sanitizedPost is the cleaned up html of a post, plus its plain text excerpt (see internal/sanitize)
+type sanitizedPost struct {
+	Description string
+	Content     string
+	Excerpt     string
+}
+
+// sanitizePost cleans up a post's description and content, which are html straight from the feed
+// link is the post's link, which relative links and images in the post are relative to
+// The excerpt comes from the description, or the content if there's no description
+func sanitizePost(link, description, content string) sanitizedPost {
+	base := postBaseURL(link)
+	excerpt := sanitize.Excerpt(description, excerptLength)
+	if excerpt == "" {
+		excerpt = sanitize.Excerpt(content, excerptLength)
+	}
+	return sanitizedPost{
+		Description: sanitize.HTML(description, base),
+		Content:     sanitize.HTML(content, base),
+		Excerpt:     excerpt,
+	}
+}
+
+// postBaseURL is what relative urls in a post are resolved against, the post's link
+// It's nil if the link isn't an absolute url, then relative urls get dropped
+func postBaseURL(link string) *url.URL {
+	if u, err := url.Parse(link); err == nil && u.IsAbs() {
+		return u
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Yendelevium/RSSAggregator/internal/database"
+	"github.com/google/uuid"
+)
+
+// Authors and categories come from all over the place:
+// RSS has <author>, dc:creator and <category>, RDF has dc:creator and dc:subject,
+// Atom has <author><name> and <category term="...">, and JSON Feed has authors and tags
+// The parsers put them all in RSSItem.Author, Creators and Categories, and this turns them into clean lists of names
+
+// Anything longer than this isn't a real name, it's a feed stuffing its whole description in there
+const maxNameLength = 200
+
+// authors returns the names of the item's authors, without duplicates
+func (item RSSItem) authors() []string {
+	names := []string{}
+	if item.Author != "" {
+		names = append(names, rssAuthorName(item.Author))
+	}
+	names = append(names, item.Creators...)
+	return cleanNames(names)
+}
+
+// categories returns the item's categories, without duplicates
+func (item RSSItem) categories() []string {
+	return cleanNames(item.Categories)
+}
+
+// rssAuthorName gets the name out of an RSS <author>, which is supposed to be "jane@x.com (Jane Doe)"
+// If there's no name, the email is all we have. And plenty of feeds just put a name in there, which we keep as is
+func rssAuthorName(author string) string {
+	author = strings.TrimSpace(author)
+	if open := strings.Index(author, "("); open > 0 && strings.HasSuffix(author, ")") {
+		if name := strings.TrimSpace(author[open+1 : len(author)-1]); name != "" {
+			return name
+		}
+	}
+	// "Jane Doe <jane@x.com>" is the other way people write it
+	if address, err := mail.ParseAddress(author); err == nil && address.Name != "" {
+		return address.Name
+	}
+	return author
+}
+
+// cleanNames squashes whitespace, and drops empty names, really long ones, and duplicates
+// Names are matched ignoring case (like in the db), and the first way it's written wins
+func cleanNames(names []string) []string {
+	cleaned := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" || len([]rune(name)) > maxNameLength || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		cleaned = append(cleaned, name)
+	}
+	return cleaned
+}
+
+// savePostAuthors links the post to its authors (creating the ones we haven't seen before),
+// and unlinks the ones the feed doesn't have on it anymore
+func savePostAuthors(ctx context.Context, db *database.Queries, postID uuid.UUID, names []string) error {
+	authorIDs := []uuid.UUID{}
+	for _, name := range names {
+		err := db.CreateAuthor(ctx, database.CreateAuthorParams{
+			ID:        uuid.New(),
+			CreatedAt: time.Now().UTC(),
+			Name:      name,
+		})
+		if err != nil {
+			return err
+		}
+		author, err := db.GetAuthorByName(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = db.AddPostAuthor(ctx, database.AddPostAuthorParams{PostID: postID, AuthorID: author.ID})
+		if err != nil {
+			return err
+		}
+		authorIDs = append(authorIDs, author.ID)
+	}
+	return db.DeleteStalePostAuthors(ctx, database.DeleteStalePostAuthorsParams{
+		PostID:    postID,
+		AuthorIds: authorIDs,
+	})
+}
+
+// savePostCategories is the same as savePostAuthors, for categories
+func savePostCategories(ctx context.Context, db *database.Queries, postID uuid.UUID, names []string) error {
+	categoryIDs := []uuid.UUID{}
+	for _, name := range names {
+		err := db.CreateCategory(ctx, database.CreateCategoryParams{
+			ID:        uuid.New(),
+			CreatedAt: time.Now().UTC(),
+			Name:      name,
+		})
+		if err != nil {
+			return err
+		}
+		category, err := db.GetCategoryByName(ctx, name)
+		if err != nil {
+			return err
+		}
+		err = db.AddPostCategory(ctx, database.AddPostCategoryParams{PostID: postID, CategoryID: category.ID})
+		if err != nil {
+			return err
+		}
+		categoryIDs = append(categoryIDs, category.ID)
+	}
+	return db.DeleteStalePostCategories(ctx, database.DeleteStalePostCategoriesParams{
+		PostID:      postID,
+		CategoryIds: categoryIDs,
+	})
+}
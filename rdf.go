@@ -0,0 +1,62 @@
+package main
+
+import "strings"
+
+// RSS 1.0 (aka RDF Site Summary) is the old RDF based version of RSS, which a lot of academic and government sites still serve
+// The root element is <rdf:RDF>, and the big difference from RSS 2.0 is that the <item>s are NOT inside the <channel>,
+// they are siblings of it. So our RSSFeed struct just never sees them
+// See http://web.resource.org/rss/1.0/spec
+
+// This is the namespace of the <rdf:RDF> root element
+const rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// The title/link/description tags don't have namespaces here, so they match both RSS 1.0 and the even older RSS 0.9 namespace
+// Dates and authors come from Dublin Core (the dc: prefix), which is a separate namespace
+type RDFFeed struct {
+	Channel struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		// The syndication module was made for RSS 1.0, so these hints are pretty common in RDF feeds
+		UpdatePeriod    string `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+		UpdateFrequency string `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
+	} `xml:"channel"`
+	Item []RDFItem `xml:"item"`
+}
+
+// Every RDF item has an rdf:about attribute with its URI, which we use as its guid
+type RDFItem struct {
+	About       string   `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Creators    []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	// RDF feeds don't have <category>, they use dc:subject for the same thing
+	Subjects []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+}
+
+// toRSSFeed converts the RDF feed into our RSSFeed, same as we do for Atom and JSON Feed
+func (rdfFeed RDFFeed) toRSSFeed() RSSFeed {
+	rssFeed := RSSFeed{}
+	rssFeed.Channel.Title = strings.TrimSpace(rdfFeed.Channel.Title)
+	rssFeed.Channel.Link = strings.TrimSpace(rdfFeed.Channel.Link)
+	rssFeed.Channel.Description = strings.TrimSpace(rdfFeed.Channel.Description)
+	rssFeed.Channel.UpdatePeriod = rdfFeed.Channel.UpdatePeriod
+	rssFeed.Channel.UpdateFrequency = rdfFeed.Channel.UpdateFrequency
+
+	for _, item := range rdfFeed.Item {
+		rssFeed.Channel.Item = append(rssFeed.Channel.Item, RSSItem{
+			Title:       strings.TrimSpace(item.Title),
+			Link:        strings.TrimSpace(item.Link),
+			Description: strings.TrimSpace(item.Description),
+			Content:     strings.TrimSpace(item.Content),
+			PubDate:     item.Date,
+			Creators:    item.Creators,
+			Categories:  item.Subjects,
+			GUID:        strings.TrimSpace(item.About),
+		})
+	}
+	return rssFeed
+}
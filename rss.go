@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -21,49 +27,124 @@ import (
 
 type RSSFeed struct {
 	Channel struct {
+		// See RSSItem for why these come first
+		ITunesTitle string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd title"`
+		AtomLink    string    `xml:"http://www.w3.org/2005/Atom link"`
 		Title       string    `xml:"title"`
 		Link        string    `xml:"link"`
 		Description string    `xml:"description"`
 		Language    string    `xml:"language"`
 		Item        []RSSItem `xml:"item"`
+		// These are hints from the feed about how often it wants to be fetched, see schedule.go
+		// They're strings (and not ints) so that one badly written hint doesn't make the whole feed fail to parse
+		TTL       string   `xml:"ttl"`
+		SkipHours []string `xml:"skipHours>hour"`
+		SkipDays  []string `xml:"skipDays>day"`
+		// sy: is the syndication module, which came from RSS 1.0 but shows up in RSS 2.0 feeds too
+		UpdatePeriod    string `xml:"http://purl.org/rss/1.0/modules/syndication/ updatePeriod"`
+		UpdateFrequency string `xml:"http://purl.org/rss/1.0/modules/syndication/ updateFrequency"`
 	} `xml:"channel"`
 }
 
 // These RSSItems are basically the posts. Again, see the xml file and ur gonna understand
 type RSSItem struct {
-	Title       string `xml:"title"`
-	Link        string `xml:"link"`
-	Description string `xml:"description"`
-	PubDate     string `xml:"pubDate"`
+	// A tag without a namespace, like xml:"title", takes <itunes:title> and <media:title> too, and the last one wins
+	// So a podcast's <itunes:title> could replace the post's real title, and <atom:link/> (which has no text) could wipe the link
+	// encoding/xml gives an element to the first field that matches it, so these namespaced fields have to come first
+	// They catch the namespaced versions, and the fields below only get the plain RSS ones. See parseFeed for how they're used
+	ITunesTitle      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd title"`
+	ITunesAuthor     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	MediaTitle       string `xml:"http://search.yahoo.com/mrss/ title"`
+	MediaDescription string `xml:"http://search.yahoo.com/mrss/ description"`
+	AtomLink         string `xml:"http://www.w3.org/2005/Atom link"`
+	Title            string `xml:"title"`
+	Link             string `xml:"link"`
+	Description      string `xml:"description"`
+	PubDate          string `xml:"pubDate"`
+	// The guid is what identifies the post within its feed. It's often the same as the link, but doesn't have to be
+	GUID string `xml:"guid"`
+	// description is often just a summary, and the whole article is in <content:encoded> (from the content module)
+	Content string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	// <author> is meant to be an email, like "jane@x.com (Jane Doe)", so most feeds use dc:creator instead
+	// dc:creator is the Dublin Core author tag. RDF feeds use it, and a lot of RSS 2.0 feeds do too. A post can have more than one
+	// The other formats fill these in too, see postmeta.go for how they get saved
+	Author     string   `xml:"author"`
+	Creators   []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Categories []string `xml:"category"`
+	// Podcast episodes and other media attached to the post, see podcast.go
+	Enclosures      []RSSEnclosure   `xml:"enclosure"`
+	ITunesDuration  string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ITunesImage     ITunesImage      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	ITunesEpisode   string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	ITunesSeason    string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	MediaContent    []MediaContent   `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaThumbnails []MediaThumbnail `xml:"http://search.yahoo.com/mrss/ thumbnail"`
 }
 
-// This will take the url to the feed as input, and will return a new type, called RSSFeed, and an error
-func urlToFeed(url string) (RSSFeed, error) {
-	// First we r gonna need a new httpClient
+// fetchResult is what we get back from fetching a feed. It's the feed itself, plus some stuff from the HTTP response
+type fetchResult struct {
+	Feed RSSFeed
+	// true when the server answered 304 Not Modified. Then there's no body, and Feed is empty
+	NotModified bool
+	// The validators the server gave us, which we send back on the next fetch (see 010_feeds_cache_validators.sql)
+	ETag         string
+	LastModified string
+	// The HTTP status code, 0 if we never got a response
+	StatusCode int
+	// How many bytes of body we downloaded
+	Bytes int64
+	// If we only got here through permanent redirects (301 or 308), this is where we ended up, so the feed has moved there
+	MovedTo string
+	// The url the feed had before it moved. The fetcher doesn't set this, the scraper does once it has saved the move
+	MovedFrom string
+}
+
+// This will take the url to the feed as input, and will return a fetchResult, which has the RSSFeed in it, and an error
+// etag and lastModified are the validators from the last fetch (empty if we don't have any), which make it a conditional GET
+// ctx lets the caller cancel the request, like when the server is shutting down
+func (f *feedFetcher) urlToFeed(ctx context.Context, url, etag, lastModified string) (fetchResult, error) {
+	// We use the fetcher's httpClient, so we get its timeout and keep reusing its connections (see fetcher.go)
 	// An httpClient sends HTTP requests and receives HTTP responses from a resource identified by a URI
 	// Basically we can use to make requests to the web server
 
-	// Timeout(type time.Duration): your client will open a connection to the server via HTTP; the server may take some time to answer your request.
-	// This field will define a maximum time to wait for the response. If you do not specify a timeout, there is no timeout by default.
-	// This can be dangerous for the user-perceived performance of your service.
-	// It’s better, in my opinion, to display an error than to make the user wait indefinitely. Note that this time includes:
-	// Connection time (the time needed to connect to the distant server)
-	// Time taken by redirects (if any)
-	// Time is taken to read the response body.
+	// Through a proxy, the dialer only checks the proxy's address, and the proxy does the DNS for the feed's host
+	// So we check the feed's url ourselves first. It's not as good as the dialer's check (the DNS could change
+	// between our lookup and the proxy's), but it stops someone from just adding a private address as a feed
+	if f.proxied {
+		if err := f.guard.CheckURL(ctx, url); err != nil {
+			return fetchResult{}, err
+		}
+	}
 
-	// We can also specify parameters for the http.Client like Transport, checkRedirect, Jar, but we don't need them now
-	// https://www.practical-go-lessons.com/chap-35-build-an-http-client
+	// We can't just do httpClient.Get() anymore, as we need to add headers to the request
+	// So we build the request ourselves, and then use the client to send it
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fetchResult{}, err
+	}
+	// Telling the site who we are, and that we can take compressed responses
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	// If the feed hasn't changed since these, the server can answer 304 Not Modified without sending the whole feed again
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
 
-	// We r gonna set the timeout to 10s coz fun
-	httpClient := http.Client{
-		Timeout: 10 * time.Second,
+	// Before sending anything, we wait our turn for the host, so we don't hammer it (see politeness.go)
+	release, err := f.hosts.acquire(ctx, req.URL.Hostname())
+	if err != nil {
+		return fetchResult{}, err
 	}
+	defer release()
 
 	// We r now gonna use that client to make a get request to the url of the feed
 	// This will return an HTPP Response, and an err
-	resp, err := httpClient.Get(url)
+	resp, err := f.client.Do(req)
 	if err != nil {
-		return RSSFeed{}, err
+		return fetchResult{}, err
 	}
 
 	// If the response is fine, we will defer a close on the response Body
@@ -71,27 +152,184 @@ func urlToFeed(url string) (RSSFeed, error) {
 	// Do not forget to add this closing instruction; otherwise, the client might not reuse a potential persistent connection to the server
 	defer resp.Body.Close()
 
-	// Now, lets read all the data from the response body. So u io.ReadAll() it
-	// This returns a slice of bytes and an error
-	dat, err := io.ReadAll(resp.Body)
+	// From here on we have a response, so even if something fails we return the result with the status code in it
+	// That way the scraper can still save it in the feed's fetch log
+	result := fetchResult{StatusCode: resp.StatusCode, MovedTo: permanentRedirectTarget(resp)}
+
+	// Nothing changed, so there's nothing to read or parse
+	// The server may send new validators with the 304, if it doesn't we just keep the ones we had
+	if resp.StatusCode == http.StatusNotModified {
+		result.NotModified = true
+		result.ETag = etag
+		result.LastModified = lastModified
+		if resp.Header.Get("ETag") != "" {
+			result.ETag = resp.Header.Get("ETag")
+		}
+		if resp.Header.Get("Last-Modified") != "" {
+			result.LastModified = resp.Header.Get("Last-Modified")
+		}
+		return result, nil
+	}
+
+	// 429 Too Many Requests and 503 Service Unavailable mean the server wants us to slow down
+	// If it says for how long, we leave the whole host alone till then, not just this feed
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			f.hosts.backOff(req.URL.Hostname(), time.Now().Add(wait))
+			return result, &retryAfterError{statusCode: resp.StatusCode, retryAfter: wait}
+		}
+	}
+
+	// Anything that isn't a 2XX is an error page, not a feed, so there's no point parsing it
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+
+	// If we got a web page, it's probably the site's homepage, so we look in it for links to the feed (see discovery.go)
+	// resp.Request.URL is where we ended up after any redirects, which is what the links in the page are relative to
+	if isHTML(resp.Header.Get("Content-Type")) {
+		body, err := decodeBody(resp)
+		if err != nil {
+			return result, err
+		}
+		limited := &limitReader{r: body, limit: f.maxBodyBytes}
+		candidates := discoverFeedLinks(resp.Request.URL, limited)
+		result.Bytes = limited.n
+		return result, &htmlPageError{pageURL: resp.Request.URL, candidates: candidates}
+	}
+
+	// If the server says what it's sending isn't a feed (like an image, or a zip file), we don't even download it
+	if err := checkFeedContentType(resp.Header.Get("Content-Type")); err != nil {
+		return result, err
+	}
+	// Same thing if it tells us upfront that the body is too big
+	if resp.ContentLength > f.maxBodyBytes {
+		return result, errFeedTooLarge{limit: f.maxBodyBytes}
+	}
+
+	// The body might be compressed, so we read it through decodeBody, which undoes that
+	body, err := decodeBody(resp)
 	if err != nil {
-		return RSSFeed{}, err
+		return result, err
 	}
 
-	// I logged it, and it's just a bunch of numbers, u can see it if u want
-	// log.Printf("%x", dat)
+	// We used to io.ReadAll() the whole body, but a feed url pointing at some giant file would eat all our memory
+	// So now we read it through a limitReader, which errors once we go over maxBodyBytes, and parse it as it comes in
+	// The limit counts the bytes after decompressing, so a tiny gzip that unzips into gigabytes gets stopped too
+	limited := &limitReader{r: body, limit: f.maxBodyBytes}
 
-	// Now, we will parse the slice of bytes into our RSSFeed type
-	// So u xml.Unmarshal instead of json.Unmarshalling
-	rssFeed := RSSFeed{}
-	err = xml.Unmarshal(dat, &rssFeed)
+	// Now, we will parse the body into our RSSFeed type
+	// parseFeed figures out which format the feed is in, and gives us back an RSSFeed either way
+	// We pass the Content-Type header too, coz that's how JSON Feeds usually tell us what they are
+	rssFeed, err := parseFeed(limited, resp.Header.Get("Content-Type"))
+	result.Bytes = limited.n
+	// The parser just sees the read fail, so we check the limitReader to give the real reason
+	if limited.exceeded {
+		return result, errFeedTooLarge{limit: f.maxBodyBytes}
+	}
 	if err != nil {
-		return RSSFeed{}, err
+		return result, err
 	}
 
-	// This returns the wholeass feed
+	// This returns the wholeass feed, along with the validators for next time
 	// We now need to write a scraper that will get the feed, and then take the indivdual posts out of it
 	// Which are basically the RSSItems
+	result.Feed = rssFeed
+	result.ETag = resp.Header.Get("ETag")
+	result.LastModified = resp.Header.Get("Last-Modified")
+	return result, nil
+
+}
+
+// Not every feed is an RSS feed. Atom feeds have a totally different layout, so if we xml.Unmarshal them
+// into an RSSFeed we just get an empty feed with no items, and no error either
+// So we look at the root element of the document first, and decide how to decode it from there
+// JSON Feeds aren't even xml, so we check for those before anything else
+// We decode straight from the reader, instead of reading it all into memory first
+func parseFeed(r io.Reader, contentType string) (RSSFeed, error) {
+	// The bufio.Reader lets us peek at the start of the body without using it up
+	buffered := bufio.NewReader(r)
+	// Peek errors if the body is shorter than 512 bytes, but still gives us what there is, which is all we need
+	head, _ := buffered.Peek(512)
+	if isJSONFeed(contentType, head) {
+		jsonFeed := JSONFeed{}
+		err := json.NewDecoder(buffered).Decode(&jsonFeed)
+		if err != nil {
+			return RSSFeed{}, err
+		}
+		// Any json would decode into an empty JSONFeed without an error, so we check it's really a JSON Feed
+		if !jsonFeed.isValid() {
+			return RSSFeed{}, errors.New("not a feed: json has no JSON Feed version or items")
+		}
+		return jsonFeed.toRSSFeed(), nil
+	}
+
+	decoder := xml.NewDecoder(buffered)
+	root, err := rootElement(decoder)
+	if err != nil {
+		return RSSFeed{}, err
+	}
+
+	// <feed xmlns="http://www.w3.org/2005/Atom"> means it's an Atom feed
+	// DecodeElement is like xml.Unmarshal, but it carries on from the root element we already read
+	if root.Name.Local == "feed" && root.Name.Space == atomNamespace {
+		atomFeed := AtomFeed{}
+		err = decoder.DecodeElement(&atomFeed, &root)
+		if err != nil {
+			return RSSFeed{}, err
+		}
+		return atomFeed.toRSSFeed(), nil
+	}
+
+	// <rdf:RDF> means it's an RSS 1.0 feed, where the items live outside of the channel
+	if root.Name.Local == "RDF" && root.Name.Space == rdfNamespace {
+		rdfFeed := RDFFeed{}
+		err = decoder.DecodeElement(&rdfFeed, &root)
+		if err != nil {
+			return RSSFeed{}, err
+		}
+		return rdfFeed.toRSSFeed(), nil
+	}
+
+	// Anything else that's valid xml (like a sitemap or an svg) would decode into an empty RSSFeed without an error
+	// So only <rss> is left, otherwise it's not a feed at all
+	if root.Name.Local != "rss" {
+		return RSSFeed{}, fmt.Errorf("not a feed: root element is <%v>", root.Name.Local)
+	}
+
+	// It's an RSS feed, like we always had
+	// So u decode xml instead of json
+	rssFeed := RSSFeed{}
+	err = decoder.DecodeElement(&rssFeed, &root)
+	if err != nil {
+		return RSSFeed{}, err
+	}
+	// The plain RSS fields always win, the itunes ones are only there for podcasts that leave them out
+	if strings.TrimSpace(rssFeed.Channel.Title) == "" {
+		rssFeed.Channel.Title = rssFeed.Channel.ITunesTitle
+	}
+	for i := range rssFeed.Channel.Item {
+		item := &rssFeed.Channel.Item[i]
+		if strings.TrimSpace(item.Title) == "" {
+			item.Title = item.ITunesTitle
+		}
+		if strings.TrimSpace(item.Author) == "" {
+			item.Author = item.ITunesAuthor
+		}
+	}
 	return rssFeed, nil
+}
 
+// rootElement reads up to the first element in the xml document, skipping the <?xml ?> header, comments etc
+// The xml.Name in it has the namespace in Space and the actual tag name in Local
+func rootElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFeedRejectsNonFeeds(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		wantErr     bool
+		wantItems   int
+	}{
+		{
+			name:        "sitemap",
+			body:        `<?xml version="1.0"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>https://x.com/</loc></url></urlset>`,
+			contentType: "application/xml",
+			wantErr:     true,
+		},
+		{
+			name:        "svg",
+			body:        `<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"></svg>`,
+			contentType: "image/svg+xml",
+			wantErr:     true,
+		},
+		{
+			name:        "feed that isn't Atom",
+			body:        `<feed><entry><title>x</title></entry></feed>`,
+			contentType: "application/xml",
+			wantErr:     true,
+		},
+		{
+			name:        "random json",
+			body:        `{"foo":1}`,
+			contentType: "application/json",
+			wantErr:     true,
+		},
+		{
+			name:        "rss",
+			body:        `<rss version="2.0"><channel><title>t</title><item><title>a</title></item></channel></rss>`,
+			contentType: "application/rss+xml",
+			wantItems:   1,
+		},
+		{
+			name:        "empty rss is still a feed",
+			body:        `<rss version="2.0"><channel><title>t</title></channel></rss>`,
+			contentType: "text/xml",
+		},
+		{
+			name:        "atom",
+			body:        `<feed xmlns="http://www.w3.org/2005/Atom"><title>t</title><entry><id>1</id></entry></feed>`,
+			contentType: "application/atom+xml",
+			wantItems:   1,
+		},
+		{
+			name:        "rdf",
+			body:        `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/"><channel><title>t</title></channel><item rdf:about="u"><title>a</title></item></rdf:RDF>`,
+			contentType: "application/rdf+xml",
+			wantItems:   1,
+		},
+		{
+			name:        "json feed with just a version",
+			body:        `{"version":"https://jsonfeed.org/version/1.1","title":"t"}`,
+			contentType: "application/feed+json",
+		},
+		{
+			name:        "json feed with just items",
+			body:        `{"title":"t","items":[{"id":"1"}]}`,
+			contentType: "application/json",
+			wantItems:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFeed(strings.NewReader(tt.body), tt.contentType)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseFeed() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFeed() error = %v", err)
+			}
+			if len(got.Channel.Item) != tt.wantItems {
+				t.Errorf("parseFeed() gave %v items, want %v", len(got.Channel.Item), tt.wantItems)
+			}
+		})
+	}
+}
+
+func TestParseFeedNamespacedFields(t *testing.T) {
+	const namespaces = `xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:media="http://search.yahoo.com/mrss/" xmlns:atom="http://www.w3.org/2005/Atom"`
+	tests := []struct {
+		name            string
+		item            string
+		wantTitle       string
+		wantLink        string
+		wantDescription string
+		wantAuthor      string
+	}{
+		{
+			name:      "itunes title after the title",
+			item:      `<title>Episode 1: The Real Title</title><itunes:title>The Real Title</itunes:title>`,
+			wantTitle: "Episode 1: The Real Title",
+		},
+		{
+			name:      "itunes title before the title",
+			item:      `<itunes:title>Short</itunes:title><title>Long title</title>`,
+			wantTitle: "Long title",
+		},
+		{
+			name:      "media title",
+			item:      `<title>Post</title><media:title>photo.jpg</media:title>`,
+			wantTitle: "Post",
+		},
+		{
+			name:            "media description",
+			item:            `<description>The post</description><media:description>A photo</media:description>`,
+			wantDescription: "The post",
+		},
+		{
+			name:     "atom link",
+			item:     `<link>https://x.com/1</link><atom:link rel="related" href="https://y.com/"/>`,
+			wantLink: "https://x.com/1",
+		},
+		{
+			name:       "itunes author",
+			item:       `<author>jane@x.com (Jane Doe)</author><itunes:author>The Podcast</itunes:author>`,
+			wantAuthor: "jane@x.com (Jane Doe)",
+		},
+		{
+			name:      "itunes title when there's no title",
+			item:      `<itunes:title>Only itunes</itunes:title>`,
+			wantTitle: "Only itunes",
+		},
+		{
+			name:       "itunes author when there's no author",
+			item:       `<itunes:author>The Podcast</itunes:author>`,
+			wantAuthor: "The Podcast",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `<rss version="2.0" ` + namespaces + `><channel><title>t</title><item>` + tt.item + `</item></channel></rss>`
+			feed, err := parseFeed(strings.NewReader(body), "application/rss+xml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			item := feed.Channel.Item[0]
+			if item.Title != tt.wantTitle {
+				t.Errorf("Title = %q, want %q", item.Title, tt.wantTitle)
+			}
+			if item.Link != tt.wantLink {
+				t.Errorf("Link = %q, want %q", item.Link, tt.wantLink)
+			}
+			if item.Description != tt.wantDescription {
+				t.Errorf("Description = %q, want %q", item.Description, tt.wantDescription)
+			}
+			if item.Author != tt.wantAuthor {
+				t.Errorf("Author = %q, want %q", item.Author, tt.wantAuthor)
+			}
+		})
+	}
+}
+
+func TestParseFeedChannelNamespacedFields(t *testing.T) {
+	body := `<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:atom="http://www.w3.org/2005/Atom"><channel>` +
+		`<title>My Podcast</title><link>https://x.com/</link><atom:link href="https://x.com/feed.xml" rel="self"/><itunes:title>Podcast</itunes:title>` +
+		`</channel></rss>`
+	feed, err := parseFeed(strings.NewReader(body), "application/rss+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Channel.Title != "My Podcast" {
+		t.Errorf("Title = %q, want %q", feed.Channel.Title, "My Podcast")
+	}
+	if feed.Channel.Link != "https://x.com/" {
+		t.Errorf("Link = %q, want %q", feed.Channel.Link, "https://x.com/")
+	}
+}
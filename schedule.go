@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Yendelevium/RSSAggregator/internal/database"
+)
+
+// Instead of fetching every feed every time the scraper ticks, each feed gets its own next fetch time
+// A feed that posts 10 times a day gets checked way more often than one that posts once a month
+// On top of that, feeds can tell us how often to check them, with <ttl>, <skipHours>, <skipDays> and sy:updatePeriod/sy:updateFrequency
+
+// How many of the most recent posts we look at to work out how often a feed posts
+const scheduleSampleSize = 20
+
+// scheduleHints are what a feed tells us about how often to fetch it
+// We save them on the feed (see 013_feeds_schedule_hints.sql), coz a 304 doesn't come with a feed to read them from
+type scheduleHints struct {
+	// The longest of <ttl> and the sy: interval. 0 if the feed has neither
+	interval  time.Duration
+	skipHours map[int]bool
+	skipDays  map[time.Weekday]bool
+}
+
+// feedScheduleHints reads the hints out of a feed we just fetched
+func feedScheduleHints(rssFeed RSSFeed) scheduleHints {
+	hints := scheduleHints{skipHours: map[int]bool{}, skipDays: map[time.Weekday]bool{}}
+
+	// <ttl> is the number of minutes the feed can be cached for, so there's no point checking before that
+	if ttl, err := strconv.Atoi(strings.TrimSpace(rssFeed.Channel.TTL)); err == nil && ttl > 0 {
+		hints.interval = time.Duration(ttl) * time.Minute
+	}
+	// sy:updatePeriod and sy:updateFrequency say the feed updates frequency times per period
+	if period := syndicationInterval(rssFeed.Channel.UpdatePeriod, rssFeed.Channel.UpdateFrequency); period > 0 {
+		hints.interval = max(hints.interval, period)
+	}
+
+	for _, hour := range rssFeed.Channel.SkipHours {
+		h, err := strconv.Atoi(strings.TrimSpace(hour))
+		if err != nil || h < 0 || h > 24 {
+			continue
+		}
+		// Some feeds use 1-24 instead of 0-23, 24 is midnight either way
+		hints.skipHours[h%24] = true
+	}
+	for _, day := range rssFeed.Channel.SkipDays {
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			if strings.EqualFold(strings.TrimSpace(day), weekday.String()) {
+				hints.skipDays[weekday] = true
+			}
+		}
+	}
+	return hints
+}
+
+// storedScheduleHints gets back the hints we saved on the feed the last time we got the whole feed
+func storedScheduleHints(feed database.Feed) scheduleHints {
+	hints := scheduleHints{skipHours: map[int]bool{}, skipDays: map[time.Weekday]bool{}}
+	if feed.HintIntervalSeconds.Valid {
+		hints.interval = time.Duration(feed.HintIntervalSeconds.Int32) * time.Second
+	}
+	for _, hour := range feed.SkipHours {
+		hints.skipHours[int(hour)] = true
+	}
+	for _, day := range feed.SkipDays {
+		hints.skipDays[time.Weekday(day)] = true
+	}
+	return hints
+}
+
+// toDB turns the hints into what we save in the feeds table
+func (hints scheduleHints) toDB() (sql.NullInt32, []int32, []int32) {
+	interval := sql.NullInt32{}
+	if hints.interval > 0 {
+		interval = sql.NullInt32{Int32: int32(min(hints.interval/time.Second, math.MaxInt32)), Valid: true}
+	}
+	// Sorted, so the same hints are always saved the same way
+	skipHours := []int32{}
+	for hour := 0; hour < 24; hour++ {
+		if hints.skipHours[hour] {
+			skipHours = append(skipHours, int32(hour))
+		}
+	}
+	skipDays := []int32{}
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if hints.skipDays[weekday] {
+			skipDays = append(skipDays, int32(weekday))
+		}
+	}
+	return interval, skipHours, skipDays
+}
+
+// nextFetchInterval works out how long to wait before fetching a feed again
+// publishDates are the feed's most recent real (not inferred) publish dates, newest first
+func nextFetchInterval(publishDates []time.Time, hints scheduleHints, cfg scrapeConfig) time.Duration {
+	// If we don't have enough posts to see a pattern, we just check as often as we're allowed to
+	interval := cfg.minInterval
+	if len(publishDates) >= 2 {
+		// The average gap between posts. If a feed posts every 3 hours, checking every 3 hours is plenty
+		newest := publishDates[0]
+		oldest := publishDates[len(publishDates)-1]
+		interval = newest.Sub(oldest) / time.Duration(len(publishDates)-1)
+	}
+
+	// The feed knows better than us how often it updates, so we never check more often than it says
+	interval = max(interval, hints.interval)
+
+	// Whatever the feed says, we stay within our own bounds
+	return min(max(interval, cfg.minInterval), cfg.maxInterval)
+}
+
+// nextFetchTime is when we should fetch the feed next, if we last fetched it at fetchedAt
+// After working out the interval, we push the time past any hours/days the feed asked us to skip
+func nextFetchTime(fetchedAt time.Time, publishDates []time.Time, hints scheduleHints, cfg scrapeConfig) time.Time {
+	next := fetchedAt.Add(nextFetchInterval(publishDates, hints, cfg)).UTC()
+
+	// A feed that skips every hour or every day would send us into an infinite loop, so we ignore those hints
+	if len(hints.skipHours) == 24 || len(hints.skipDays) == 7 {
+		return next
+	}
+
+	// The spec says skipHours and skipDays are in GMT. We move forward an hour at a time till we're out of the skipped ones
+	// A week of hours is the most we could ever need to move
+	for i := 0; i < 7*24; i++ {
+		if !hints.skipHours[next.Hour()] && !hints.skipDays[next.Weekday()] {
+			break
+		}
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+	return next
+}
+
+// syndicationInterval turns sy:updatePeriod and sy:updateFrequency into a duration
+// The period defaults to daily, and the frequency to 1, like the spec says
+func syndicationInterval(updatePeriod, updateFrequency string) time.Duration {
+	updatePeriod = strings.ToLower(strings.TrimSpace(updatePeriod))
+	updateFrequency = strings.TrimSpace(updateFrequency)
+	if updatePeriod == "" && updateFrequency == "" {
+		return 0
+	}
+
+	var period time.Duration
+	switch updatePeriod {
+	case "hourly":
+		period = time.Hour
+	case "", "daily":
+		period = 24 * time.Hour
+	case "weekly":
+		period = 7 * 24 * time.Hour
+	case "monthly":
+		period = 30 * 24 * time.Hour
+	case "yearly":
+		period = 365 * 24 * time.Hour
+	default:
+		return 0
+	}
+
+	frequency := 1
+	if updateFrequency != "" {
+		f, err := strconv.Atoi(updateFrequency)
+		if err != nil || f < 1 {
+			return 0
+		}
+		frequency = f
+	}
+	return period / time.Duration(frequency)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yendelevium/RSSAggregator/internal/database"
+)
+
+// On a 304 we only have the hints we saved, so saving and loading them has to give back the same schedule
+func TestScheduleHintsSurviveSaving(t *testing.T) {
+	rssFeed := RSSFeed{}
+	rssFeed.Channel.TTL = "120"
+	rssFeed.Channel.SkipHours = []string{"0", "1", "24", "nope"}
+	rssFeed.Channel.SkipDays = []string{"Saturday", "sunday"}
+
+	hints := feedScheduleHints(rssFeed)
+	interval, skipHours, skipDays := hints.toDB()
+	stored := storedScheduleHints(database.Feed{HintIntervalSeconds: interval, SkipHours: skipHours, SkipDays: skipDays})
+
+	cfg := scrapeConfig{minInterval: 15 * time.Minute, maxInterval: 24 * time.Hour}
+	// A Friday at 22:30 GMT. The ttl makes it 00:30 on Saturday, which is skipped all day, and so is Sunday,
+	// and then 00:00 and 01:00 on Monday are skipped too
+	fetchedAt := time.Date(2024, 3, 1, 22, 30, 0, 0, time.UTC)
+	want := time.Date(2024, 3, 4, 2, 0, 0, 0, time.UTC)
+
+	for name, h := range map[string]scheduleHints{"from the feed": hints, "saved": stored} {
+		if got := nextFetchTime(fetchedAt, nil, h, cfg); !got.Equal(want) {
+			t.Errorf("nextFetchTime() with hints %v = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestNextFetchIntervalHints(t *testing.T) {
+	cfg := scrapeConfig{minInterval: 15 * time.Minute, maxInterval: 24 * time.Hour}
+	tests := []struct {
+		name  string
+		hints scheduleHints
+		want  time.Duration
+	}{
+		{name: "no hints", hints: scheduleHints{}, want: 15 * time.Minute},
+		{name: "ttl", hints: scheduleHints{interval: time.Hour}, want: time.Hour},
+		{name: "capped at maxInterval", hints: scheduleHints{interval: 7 * 24 * time.Hour}, want: 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextFetchInterval(nil, tt.hints, cfg); got != tt.want {
+				t.Errorf("nextFetchInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
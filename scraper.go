@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"log"
 	"strings"
 	"sync"
@@ -15,102 +18,257 @@ import (
 // The scraper is a long running job. This scraper function will run on the bg of our server
 // as long as the server is up
 
-// This will take 3 inputs, a connection to our database, how many diff goroutines we wanna do the scraping on,
-// And the time delay between each request to scrape a new RSSFeed
-// It won't return anything as it will be running forever as long as our server is up
+// When a fetch fails, we wait backoffBase before trying that feed again, and double the wait on every failure after that
+// So 5m, 10m, 20m, 40m... but never more than backoffMax
+const (
+	backoffBase = 5 * time.Minute
+	backoffMax  = 24 * time.Hour
+)
+
+// When the scraper claims a feed, no other scraper can claim it for this long
+// It has to be longer than fetching and saving a feed ever takes, otherwise another scraper could grab a feed we're still working on
+// If a scraper dies while it has a feed, this is also how long it takes for the feed to be picked up again
+const feedLeaseDuration = 5 * time.Minute
+
+// scrapeConfig holds the settings for when the scraper fetches each feed, and how it treats feeds that keep failing
+type scrapeConfig struct {
+	// After this many failed fetches in a row, the feed gets disabled till its owner re-enables it
+	maxFailures int
+	// No matter how often a feed posts (or what it tells us), we never fetch it more often than minInterval
+	// or less often than maxInterval. See schedule.go
+	minInterval time.Duration
+	maxInterval time.Duration
+	// How many rows of feed_fetch_log we keep per feed. Older ones get deleted after every fetch
+	fetchLogKeep int
+}
+
+// This will take 6 inputs, a context, a connection to our database, the feedFetcher, how many diff goroutines we wanna do the scraping on,
+// how long to wait before checking again when no feeds are due, and the scrapeConfig
+// It won't return anything. It runs as long as our server is up, and returns once ctx is cancelled (when the server shuts down)
+// and the feeds the workers were on are finished
+
+// We used to grab a batch of feeds, scrape them all at the same time, and wait for the whole batch to finish before the next one
+// The problem with that is one slow feed (up to the 10s timeout) held up the whole next batch
+// So now there's a pool of worker goroutines that live as long as the scraper, and a dispatcher that keeps claiming due feeds
+// and handing them to whichever worker is free. A slow feed only ties up its own worker
 
 func startScraping(
-	db *database.Queries,
+	ctx context.Context,
+	conn *sql.DB,
+	fetcher *feedFetcher,
 	concurrency int,
 	timeBewteenRequest time.Duration,
+	cfg scrapeConfig,
 ) {
 	// Because this scraper is gonna run in the bg of our server, it will be good if we know what is going on while its doing its thing
 	// Hence, we will need a lot of good logging, to know what's up
-	log.Printf("Scraping on %v goroutines every %s duration", concurrency, timeBewteenRequest)
+	log.Printf("Scraping on %v workers, checking for due feeds every %s, each feed every %s to %s", concurrency, timeBewteenRequest, cfg.minInterval, cfg.maxInterval)
+
+	// jobs is how the dispatcher hands feeds to the workers
+	// done gets a value every time a worker finishes a feed, so the dispatcher knows it can claim another one
+	// It's buffered with room for every worker, so a worker never has to wait on the dispatcher to take it
+	jobs := make(chan database.Feed)
+	done := make(chan struct{}, concurrency)
+
+	// The waitGroup is how we know all the workers have finished up when we're shutting down
+	// The way that waitGroup works, is that anytime u wanna make a new goroutine in the context of that wg,
+	// U wg.Add(<number>) where number is the no.of goroutines ur making
+	// The scraper mostly uses the sqlc queries, but it needs the connection itself for transactions (see feedmove.go)
+	db := database.New(conn)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go scrapeWorker(ctx, conn, db, fetcher, wg, jobs, done, cfg)
+	}
+
+	dispatchFeeds(ctx, db, concurrency, timeBewteenRequest, jobs, done)
+
+	// Closing jobs makes the workers' for-range loops end once they finish what they're on
+	// Then wg.Wait() waits for that, which is what makes shutdown graceful, we never leave a feed half saved
+	close(jobs)
+	wg.Wait()
+	log.Println("Scraper stopped")
+}
 
-	// We need to figure out how we wanna make requests in the given time interval
+// dispatchFeeds keeps the workers busy. Whenever some workers are free, it claims that many due feeds and hands them out
+// If no feeds are due, it waits for the ticker before checking again. It returns when ctx is cancelled
+func dispatchFeeds(
+	ctx context.Context,
+	db *database.Queries,
+	concurrency int,
+	timeBewteenRequest time.Duration,
+	jobs chan<- database.Feed,
+	done <-chan struct{},
+) {
+	// We need to figure out how often we check for due feeds, when there weren't any last time
 	// This is where a ticker comes to play
 	// NewTicker returns a new Ticker containing a channel that will send the current time on the channel after each tick.
 	// The period of the ticks is specified by the duration argument. The ticker will adjust the time interval or drop ticks to make up for slow receivers.
 	// The duration d must be greater than zero; if not, NewTicker will panic.
-
 	ticker := time.NewTicker(timeBewteenRequest)
+	defer ticker.Stop()
 
-	// ticker.C is the channel of the ticker
-	// The reason we r passing in ; ;<blah> in the forloop, is so that the first time, the for loop
-	// starts immediately, and then it waits for 1 min(assuming that's the time interval) till the channel returns a value(remember, channels can block)
-	// And then it goes again
-	// Basically, so that we initially don't have to wait for 1 min before we start scraping
-	// If we just did : for range ticker.C, it will wait for the time first, then scrape
-	for ; ; <-ticker.C {
+	// How many workers are free right now. They all start out free
+	idle := concurrency
 
-		// Every interval, we wanna go grab the next batch of feeds to fetch
-		// The function takes a context, and the no.of feeds u wanna fetch, which will be the no.of goroutines running at the same time
-		// So we pass concurrency here
+	for ctx.Err() == nil {
+		// Every worker that finished since we last looked is free again
+		idle += drain(done)
+
+		// If every worker is busy, there's no point claiming anything, we just wait for one of them to finish
+		if idle == 0 {
+			select {
+			case <-ctx.Done():
+			case <-done:
+				idle++
+			}
+			continue
+		}
 
 		// Since we don't have a request body, we can't just do r.Context()
-		// So we use context.Background(), which is basically a global context, and it's what we use when we don't
-		// have scoped contexts, like our individually http requests(r.context())
+		// So we use the ctx main gave us, which gets cancelled when the server shuts down
 
+		// This claims the feeds for us, so that if there are other copies of the server running, their scrapers skip these feeds
+		// We only ask for as many as we have free workers for
 		// This will return the RSSFeeds and an error
-		feeds, err := db.GetNextFeedsToFetch(
-			context.Background(),
-			int32(concurrency),
-		)
+		feeds, err := db.ClaimNextFeedsToFetch(ctx, database.ClaimNextFeedsToFetchParams{
+			Limit:        int32(idle),
+			LeaseSeconds: int32(feedLeaseDuration.Seconds()),
+		})
 
-		// While handling the error, we aren't terminating the function, but instead, continuing to the next scrape
+		// While handling the error, we aren't terminating the function, but instead, waiting and trying again
 		// This is because this function, should always be running in the bg as our server operates
-		// So we instead, log the error and wait for the next scrape
+		// So we instead, log the error and wait for the next tick
 		if err != nil {
 			log.Println("error fetching feeds:", err)
+			waitForTick(ctx, ticker.C)
 			continue
 		}
 
-		// Now that we have a slice of feeds, we need to fetch these feeds individually, and more importantly
-		// It fetches the feeds at the same time. SO we r gonna neeed a synchronization method, so we r gonna use a waitGroup
-		wg := &sync.WaitGroup{}
+		// A free worker will pick each of these up straight away
 		for _, feed := range feeds {
-			// The way that waitGroup works, is that anytime u wanna make a new goroutine in the context of that wg,
-			// U wg.Add(<number>) where number is the no.of goroutines ur making
-			// Since in every iteration of this for loop, we are making 1 goroutine to fetch 1 feed, we add 1 to the wg
-			wg.Add(1)
+			jobs <- feed
+			idle--
+		}
 
-			// Now lets spawn a new goroutine to get the feed. Here, we will pass the wg in as one of the params
-			// And within the function, we will defer wg.Done(), so it will know that that goroutine is finished
-			// The wg will allow us to call various goroutines at the same time, and will block the function, till all of them r done
-			// Which is what we wanna do as we don't wanna continue to the next iteration of the loop until we r sure we have scraped all the feeds
-			go scrapeFeed(db, wg, feed)
+		// If we got fewer feeds than we asked for, there's nothing else due right now, so we wait for the next tick
+		// Otherwise there might be more, so we go right back round (and wait for a worker to be free if we have to)
+		if idle > 0 {
+			waitForTick(ctx, ticker.C)
 		}
-		// Now at the end of the loop, we add a wg.Wait(), which will wait till all the goroutines are done
-		// Only then will it proceed
-		wg.Wait()
 	}
 }
 
-// This function will iterate through all the POSTS, (RSSItems), in the feed
-// Within the function, we will defer wg.Done(), so wg will know that that goroutine is finished
-// This function will need a db connection, and also a specific feed to fetch
-func scrapeFeed(db *database.Queries, wg *sync.WaitGroup, feed database.Feed) {
+// scrapeWorker scrapes feeds from jobs one at a time, till jobs gets closed
+func scrapeWorker(
+	ctx context.Context,
+	conn *sql.DB,
+	db *database.Queries,
+	fetcher *feedFetcher,
+	wg *sync.WaitGroup,
+	jobs <-chan database.Feed,
+	done chan<- struct{},
+	cfg scrapeConfig,
+) {
+	// Within the function, we will defer wg.Done(), so it will know that that goroutine is finished
 	defer wg.Done()
+	for feed := range jobs {
+		scrapeFeed(ctx, conn, db, fetcher, feed, cfg)
+		done <- struct{}{}
+	}
+}
 
-	// The first thing this function should do, is to mark that we r fetching this feed
-	// Here also we will pass context.Bg() as again, we don't have access to a scoped context
-	// This returns the feed which we marked as fetched, but we don't need it coz we already have it
-	_, err := db.MarkFeedAsFetched(context.Background(), feed.ID)
-	// If we can't mark it as fetched , we'll just log there was an issue and return nothing
-	if err != nil {
-		log.Println("Error marking feed as fetched:", err)
-		return
+// drain takes everything that's waiting in the channel without blocking, and returns how many it took
+func drain(ch <-chan struct{}) int {
+	n := 0
+	for {
+		select {
+		case <-ch:
+			n++
+		default:
+			return n
+		}
 	}
+}
+
+// waitForTick blocks till the next tick, or till ctx is cancelled, whichever comes first
+func waitForTick(ctx context.Context, tick <-chan time.Time) {
+	select {
+	case <-ctx.Done():
+	case <-tick:
+	}
+}
+
+// This function will iterate through all the POSTS, (RSSItems), in the feed
+// This function will need a db connection, and also a specific feed to fetch
+// conn is only used if the feed has moved, as moving it has to happen in a transaction
+// ctx is cancelled when the server shuts down. That stops the download, but once we have the feed, we still save all of it
+func scrapeFeed(ctx context.Context, conn *sql.DB, db *database.Queries, fetcher *feedFetcher, feed database.Feed, cfg scrapeConfig) {
+	// We don't want a shutdown to stop us halfway through saving the posts, so the db writes use a context
+	// that has everything from ctx, except the cancellation
+	dbCtx := context.WithoutCancel(ctx)
+
+	// We used to mark the feed as fetched first thing here, but ClaimNextFeedsToFetch already did that when it leased the feed to us
 
 	// Now we have tp scrape the feed, and we hv already written the function for that, so lets use it
 	// We log any errors
-	rssFeed, err := urlToFeed(feed.Url)
+	// We pass the validators from last time, so if the feed didn't change we get a 304 instead of the whole feed
+	// We also time it, so we can save how long the fetch took in the fetch log
+	fetchStart := time.Now()
+	result, err := fetcher.urlToFeed(ctx, feed.Url, feed.Etag.String, feed.LastModified.String)
 	if err != nil {
+		// If we're shutting down, the fetch failing isn't the feed's fault, so we don't count it against the feed
+		// We let go of the lease, so another copy of the server can fetch it right away
+		if ctx.Err() != nil {
+			log.Printf("Fetch of feed %s cancelled by shutdown", feed.Name)
+			err = db.ReleaseFeedLease(dbCtx, feed.ID)
+			if err != nil {
+				log.Println("Error releasing feed lease:", err)
+			}
+			return
+		}
 		log.Println("Error fetching feed:", err)
+		recordFetch(dbCtx, db, cfg, feed, result, time.Since(fetchStart), 0, err)
 		return
 	}
 
+	// Every redirect on the way was permanent, so the feed has moved for good, and we save its new url
+	// Otherwise we'd follow the redirect on every single fetch, and never notice when a site moves domains
+	// If the new url is already another feed, the 2 get merged, and the feed we fetched doesn't exist anymore
+	if result.MovedTo != "" {
+		movedFeed, merged, moveErr := moveFeed(dbCtx, conn, feed, result.MovedTo)
+		if moveErr != nil {
+			log.Printf("Error moving feed %s to %s: %v", feed.Name, result.MovedTo, moveErr)
+		} else if merged {
+			// The feed it got merged into has its own schedule and lease, and another worker could be fetching it right now
+			// So we leave those alone, and don't save any posts either, the next fetch of that feed gets them
+			// We only save this fetch in the history, which moved over with the merge, so it's the survivor's now
+			log.Printf("Feed %s moved to %s, which is feed %s, so they got merged", feed.Name, result.MovedTo, movedFeed.Name)
+			result.MovedFrom = feed.Url
+			logFetch(dbCtx, db, cfg, movedFeed.ID, result, time.Since(fetchStart), 0, nil)
+			return
+		} else if movedFeed.Url != feed.Url {
+			log.Printf("Feed %s moved from %s to %s", feed.Name, feed.Url, movedFeed.Url)
+			result.MovedFrom = feed.Url
+			feed = movedFeed
+		}
+	}
+
+	// 304 Not Modified means there's nothing new, which is a totally fine outcome, so we're done here
+	if result.NotModified {
+		log.Printf("Feed %s not modified since last fetch", feed.Name)
+		recordFetch(dbCtx, db, cfg, feed, result, time.Since(fetchStart), 0, nil)
+		return
+	}
+	rssFeed := result.Feed
+
+	// Posts with no (or a broken) date get this as their published_at
+	fetchedAt := time.Now().UTC()
+	newPosts, updatedPosts := 0, 0
+	// If we failed to save a post, we shouldn't save the validators, otherwise the next fetch is a 304 and we never retry that post
+	saveFailed := false
+
 	// Now, we can iterate through each post, which will be in the slice: rssFeed.channel.item
 	for _, item := range rssFeed.Channel.Item {
 
@@ -126,44 +284,272 @@ func scrapeFeed(db *database.Queries, wg *sync.WaitGroup, feed database.Feed) {
 			description.Valid = true
 		}
 
+		// content is the full article, which a lot of feeds don't have, so it's a NullString too
+		content := sql.NullString{}
+		if strings.TrimSpace(item.Content) != "" {
+			content.String = strings.TrimSpace(item.Content)
+			content.Valid = true
+		}
+
+		// The html from the feed could have scripts and tracking pixels in it, so we save a cleaned up version too
+		// That's what the API gives out, the raw html is just so we can tell when the post changes
+		sanitized := sanitizePost(item.Link, description.String, content.String)
+
 		// same thing here, PubDate is a String, but for the params we need a time.Time type
-		// So we are parsing the string and giving back this specific time format
-		// This is the time layout the guy used on his blog, but to make this project more inclusive,
-		// U prolly have to take care of all the different time layouts
-		// But for now this is fine
-		pubAt, err := time.Parse(time.RFC1123Z, item.PubDate)
+		// Feeds use all sorts of date layouts, so parsePubDate tries a bunch of them
+		// If it still can't figure it out, we don't wanna store year 0001 (that breaks the ordering of the posts),
+		// so we just say it was published when we fetched it, and flag that the date is inferred
+		pubAt, err := parsePubDate(item.PubDate)
+		pubAtInferred := false
 		if err != nil {
-			log.Printf("couldn;t parse date %v with err %v", item.PubDate, err)
-		}
-		// Now we gonna create the post in the post table finally
-		// This returns the post, which we don't need, and an error
-		_, err = db.CreatePost(context.Background(),
-			database.CreatePostParams{
-				ID:          uuid.New(),
-				CreatedAt:   time.Now().UTC(),
-				UpdateAt:    time.Now().UTC(),
+			if item.PubDate != "" {
+				log.Printf("couldn't parse date %v with err %v", item.PubDate, err)
+			}
+			pubAt = fetchedAt
+			pubAtInferred = true
+		}
+		guid := itemGUID(item)
+		// If we saved this post before posts had guids, that row gets the guid now,
+		// so it doesn't get saved a second time. The old rows are found by their url
+		if item.Link != "" {
+			err = db.AdoptLegacyPost(dbCtx, database.AdoptLegacyPostParams{
+				Guid:   guid,
+				FeedID: feed.ID,
+				Url:    item.Link,
+			})
+			if err != nil {
+				log.Println("failed to check for an old copy of the post:", err)
+				saveFailed = true
+				continue
+			}
+		}
+
+		// Now we gonna create the post in the post table finally, or update it if it changed since we last saw it
+		// This returns the post, and an error
+		// Postgres only keeps microseconds, so savedAt is truncated to that. Then it comes back from the db exactly the same,
+		// and we can tell an edit (update_at is savedAt) from content just getting filled in (update_at stays the old one)
+		postID := uuid.New()
+		savedAt := time.Now().UTC().Truncate(time.Microsecond)
+		post, err := db.UpsertPost(dbCtx,
+			database.UpsertPostParams{
+				ID:          postID,
+				CreatedAt:   savedAt,
+				UpdateAt:    savedAt,
 				Title:       item.Title,
 				Description: description,
 				PublishedAt: pubAt,
 				Url:         item.Link,
 				FeedID:      feed.ID,
+				// This tells clients that we made the date up
+				PublishedAtInferred: pubAtInferred,
+				// (feed_id, guid) is what makes a post unique now, not the url
+				Guid:    guid,
+				Content: content,
+				// sql.NullString with Valid false is NULL, which is what we want for a post with no content
+				DescriptionSanitized: sql.NullString{String: sanitized.Description, Valid: description.Valid},
+				ContentSanitized:     sql.NullString{String: sanitized.Content, Valid: content.Valid},
+				Excerpt:              sql.NullString{String: sanitized.Excerpt, Valid: sanitized.Excerpt != ""},
 			})
 
+		enclosures := item.enclosures()
+		authors := item.authors()
+		categories := item.categories()
+
 		// Logging the error
 		if err != nil {
-			// Here, since we keep scraping all the posts and try to add them to the posts
-			// We can get an error as of a post is already scraped, it's url won't be unique, so we get a duplicate key error
+			// Here, since we keep scraping all the posts, most of them will be posts we already have, that haven't changed
+			// The upsert doesn't touch those rows, so it returns no rows, and we get sql.ErrNoRows
 			// This is expected behaviour, so we don't need to log this error
-			// So if out error contains "dupliucate key", we are just gonna skip the logging part
-			// err.Error() will return the error STRING, and then we can use strings.Contains() to check if the err has duplicate key in it.
-			if strings.Contains(err.Error(), "duplicate key") {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Println("failed to save post:", err)
+				saveFailed = true
+				continue
+			}
+			// The post didn't change, but its enclosures, authors and categories still could have (like a new CDN url),
+			// or it's from before we saved them, or saving them failed last time. So if it has any, we look the post up and save them
+			// Stuff that didn't change doesn't get written again, see UpsertPostEnclosure and postmeta.go
+			if len(enclosures) == 0 && len(authors) == 0 && len(categories) == 0 {
+				continue
+			}
+			post.ID, err = db.GetPostIDByGUID(dbCtx, database.GetPostIDByGUIDParams{
+				FeedID: feed.ID,
+				Guid:   guid,
+			})
+			if err != nil {
+				log.Println("failed to get post:", err)
+				saveFailed = true
 				continue
 			}
-			log.Println("failed to create post:", err)
+		} else if post.ID == postID {
+			// The id we made up only ends up in the db if the post got inserted
+			newPosts++
+		} else if post.UpdateAt.Equal(savedAt) {
+			updatedPosts++
+		}
+		// Otherwise the post only got the content it didn't have before (015_posts_content.sql), which isn't an edit
+
+		err = savePostEnclosures(dbCtx, db, post.ID, enclosures)
+		if err != nil {
+			log.Println("failed to save enclosures:", err)
+			saveFailed = true
+		}
+		err = savePostAuthors(dbCtx, db, post.ID, authors)
+		if err != nil {
+			log.Println("failed to save authors:", err)
+			saveFailed = true
+		}
+		err = savePostCategories(dbCtx, db, post.ID, categories)
+		if err != nil {
+			log.Println("failed to save categories:", err)
+			saveFailed = true
+		}
+	}
+
+	// Now that the posts are saved, remember the validators for the next fetch
+	if !saveFailed {
+		err = db.UpdateFeedValidators(dbCtx, database.UpdateFeedValidatorsParams{
+			ID:           feed.ID,
+			Etag:         sql.NullString{String: result.ETag, Valid: result.ETag != ""},
+			LastModified: sql.NullString{String: result.LastModified, Valid: result.LastModified != ""},
+		})
+		if err != nil {
+			log.Println("Error saving feed validators:", err)
 		}
 	}
 
 	// Just doing some logging so we know how many posts we collected and from which feed
-	log.Printf("Feed %s collected, %v posts found", feed.Name, len(rssFeed.Channel.Item))
+	log.Printf("Feed %s collected, %v posts found, %v new, %v updated", feed.Name, len(rssFeed.Channel.Item), newPosts, updatedPosts)
+	recordFetch(dbCtx, db, cfg, feed, result, time.Since(fetchStart), newPosts, nil)
+}
+
+// recordFetch saves how a fetch attempt went in the feed_fetch_log table, and updates the health of the feed
+// fetchErr is nil if the fetch worked
+// Like everything else in the scraper, if this fails we just log it, as there's no one to return the error to
+func recordFetch(ctx context.Context, db *database.Queries, cfg scrapeConfig, feed database.Feed, result fetchResult, duration time.Duration, newPosts int, fetchErr error) {
+	logFetch(ctx, db, cfg, feed.ID, result, duration, newPosts, fetchErr)
 
+	errText := sql.NullString{}
+	if fetchErr != nil {
+		errText.String = fetchErr.Error()
+		errText.Valid = true
+	}
+
+	var err error
+	// The host told us to slow down, so we push the next fetch out by as long as it asked
+	// That's the host being busy, not the feed being broken, so it doesn't count towards disabling the feed
+	var retryErr *retryAfterError
+	if errors.As(fetchErr, &retryErr) {
+		err = db.PostponeFeedFetch(ctx, database.PostponeFeedFetchParams{
+			ID:          feed.ID,
+			LastError:   errText,
+			NextFetchAt: sql.NullTime{Time: time.Now().UTC().Add(retryErr.retryAfter), Valid: true},
+		})
+	} else if fetchErr != nil {
+		// feed.ConsecutiveFailures is from before this fetch, so this failure makes it one more
+		failures := int(feed.ConsecutiveFailures) + 1
+		err = db.MarkFeedFetchFailed(ctx, database.MarkFeedFetchFailedParams{
+			ID:          feed.ID,
+			LastError:   errText,
+			NextFetchAt: sql.NullTime{Time: time.Now().UTC().Add(backoffDelay(failures)), Valid: true},
+			MaxFailures: int32(cfg.maxFailures),
+		})
+		if err == nil && failures >= cfg.maxFailures {
+			log.Printf("Feed %s failed %v times in a row, disabling it", feed.Name, failures)
+		}
+	} else {
+		// Work out when to fetch the feed next, from how often it posts and the hints in the feed
+		// On a 304 there's no feed to read the hints from, so we use the ones we saved the last time we got the feed
+		hints := feedScheduleHints(result.Feed)
+		if result.NotModified {
+			hints = storedScheduleHints(feed)
+		}
+		publishDates, datesErr := db.GetRecentPublishDates(ctx, database.GetRecentPublishDatesParams{
+			FeedID: feed.ID,
+			Limit:  scheduleSampleSize,
+		})
+		if datesErr != nil {
+			log.Println("Error getting publish dates:", datesErr)
+		}
+		nextFetchAt := nextFetchTime(time.Now().UTC(), publishDates, hints, cfg)
+
+		hintInterval, skipHours, skipDays := hints.toDB()
+		err = db.MarkFeedFetchSucceeded(ctx, database.MarkFeedFetchSucceededParams{
+			ID:                  feed.ID,
+			NextFetchAt:         sql.NullTime{Time: nextFetchAt, Valid: true},
+			HintIntervalSeconds: hintInterval,
+			SkipHours:           skipHours,
+			SkipDays:            skipDays,
+		})
+	}
+	if err != nil {
+		log.Println("Error updating feed status:", err)
+	}
+}
+
+// logFetch only saves the fetch in the feed_fetch_log table, and keeps the table from growing forever
+func logFetch(ctx context.Context, db *database.Queries, cfg scrapeConfig, feedID uuid.UUID, result fetchResult, duration time.Duration, newPosts int, fetchErr error) {
+	errText := sql.NullString{}
+	if fetchErr != nil {
+		errText.String = fetchErr.Error()
+		errText.Valid = true
+	}
+	// 0 means we never got a response, so it's NULL in the db
+	statusCode := sql.NullInt32{}
+	if result.StatusCode != 0 {
+		statusCode.Int32 = int32(result.StatusCode)
+		statusCode.Valid = true
+	}
+
+	_, err := db.CreateFeedFetchLog(ctx, database.CreateFeedFetchLogParams{
+		ID:           uuid.New(),
+		CreatedAt:    time.Now().UTC(),
+		FeedID:       feedID,
+		StatusCode:   statusCode,
+		DurationMs:   int32(duration.Milliseconds()),
+		Bytes:        result.Bytes,
+		ItemCount:    int32(len(result.Feed.Channel.Item)),
+		NewPostCount: int32(newPosts),
+		Error:        errText,
+		MovedFrom:    sql.NullString{String: result.MovedFrom, Valid: result.MovedFrom != ""},
+	})
+	if err != nil {
+		log.Println("Error saving feed fetch log:", err)
+	}
+	err = db.PruneFeedFetchLogs(ctx, database.PruneFeedFetchLogsParams{
+		FeedID: feedID,
+		Keep:   int32(cfg.fetchLogKeep),
+	})
+	if err != nil {
+		log.Println("Error pruning feed fetch log:", err)
+	}
+}
+
+// backoffDelay works out how long to wait before fetching a feed again, after it failed failures times in a row
+func backoffDelay(failures int) time.Duration {
+	delay := backoffBase
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		// We check as we go, so the delay never gets big enough to overflow
+		if delay >= backoffMax {
+			return backoffMax
+		}
+	}
+	return delay
+}
+
+// itemGUID gives us the identity of an item within its feed
+// Most feeds give us a guid (or an Atom id), and when they don't, the link is the next best thing
+// Items with no link either get a hash of their title, so they still get stored as separate posts
+// The identity can only use stuff that doesn't change when the post gets edited, otherwise an edit would be a whole new post
+func itemGUID(item RSSItem) string {
+	guid := strings.TrimSpace(item.GUID)
+	if guid != "" {
+		return guid
+	}
+	link := strings.TrimSpace(item.Link)
+	if link != "" {
+		return link
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(item.Title)))
+	return "sha256:" + hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,176 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Yendelevium/RSSAggregator/internal/database"
+	"github.com/Yendelevium/RSSAggregator/internal/netguard"
+	"github.com/google/uuid"
+)
+
+// This runs a few scrapers against the same database, like a few copies of the server would, and checks that
+// ClaimNextFeedsToFetch never hands the same feed to more than one of them
+// It needs a real Postgres with the migrations run, so it only builds with -tags integration, and needs DB_URL:
+//
+//	cd sql/schema && goose postgres "$DB_URL" up && cd ../..
+//	DB_URL=... go test -tags integration -run TestScrapersShareFeeds .
+//
+// Use a throwaway database, the scrapers will fetch every due feed in it
+func TestScrapersShareFeeds(t *testing.T) {
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		t.Skip("DB_URL isn't set")
+	}
+	conn, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	db := database.New(conn)
+	ctx := context.Background()
+
+	existing, err := db.GetFeeds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) > 0 {
+		t.Skip("the database already has feeds in it, this test needs an empty one")
+	}
+
+	const (
+		scrapers = 4
+		workers  = 3
+		feeds    = 40
+	)
+
+	// Every feed has its own path on the test server, so we can count how many times each one got fetched
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		// A bit of a wait, so the scrapers are all claiming feeds at the same time
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<rss version="2.0"><channel><title>%[1]v</title><item><title>post</title><guid>%[1]v/1</guid></item></channel></rss>`, r.URL.Path)
+	}))
+	defer server.Close()
+
+	user, err := db.CreateUser(ctx, database.CreateUserParams{
+		ID:        uuid.New(),
+		CreatedAt: time.Now().UTC(),
+		UpdateAt:  time.Now().UTC(),
+		Name:      "scraper integration test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deleting the user deletes their feeds, and the feeds' posts and logs along with them
+	defer conn.Exec(`DELETE FROM users WHERE id = $1`, user.ID)
+
+	for i := 0; i < feeds; i++ {
+		feedURL := fmt.Sprintf("%v/feed/%v", server.URL, i)
+		_, err := db.CreateFeed(ctx, database.CreateFeedParams{
+			ID:            uuid.New(),
+			CreatedAt:     time.Now().UTC(),
+			UpdateAt:      time.Now().UTC(),
+			Name:          fmt.Sprintf("feed %v", i),
+			Url:           feedURL,
+			UserID:        user.ID,
+			NormalizedUrl: feedURL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The test server is on 127.0.0.1, which the guard blocks unless it's allowed
+	guard, err := netguard.New([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A fetched feed isn't due again for an hour, so any second fetch during the test means it got claimed twice
+	cfg := scrapeConfig{maxFailures: 10, minInterval: time.Hour, maxInterval: 24 * time.Hour, fetchLogKeep: 100}
+
+	scrapeCtx, stop := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < scrapers; i++ {
+		// Each scraper gets its own fetcher, like it would in its own copy of the server
+		fetcher, err := newFeedFetcher(feedFetcherConfig{
+			timeout:      5 * time.Second,
+			contactURL:   "https://example.com",
+			maxBodyBytes: 1 << 20,
+			guard:        guard,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every feed is on the same host, so the politeness limits would just make the test slow
+		fetcher.hosts = newHostLimiter(workers, 0)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			startScraping(scrapeCtx, conn, fetcher, workers, 50*time.Millisecond, cfg)
+		}()
+	}
+
+	// Waiting till every feed has been fetched, and then a bit more, so a second fetch of any of them would show up
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		mu.Lock()
+		fetched := len(fetches)
+		mu.Unlock()
+		if fetched == feeds || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond)
+	stop()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(fetches) != feeds {
+		t.Errorf("%v of %v feeds got fetched", len(fetches), feeds)
+	}
+	for path, count := range fetches {
+		if !strings.HasPrefix(path, "/feed/") {
+			t.Errorf("unexpected request for %v", path)
+			continue
+		}
+		if count != 1 {
+			t.Errorf("%v was fetched %v times, want 1", path, count)
+		}
+	}
+
+	// Every feed should have let go of its lease, and have exactly one fetch in its log
+	var leased, logs int
+	err = conn.QueryRow(`SELECT COUNT(*) FROM feeds WHERE user_id = $1 AND lease_expires_at IS NOT NULL`, user.ID).Scan(&leased)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leased != 0 {
+		t.Errorf("%v feeds are still leased after the scrapers stopped", leased)
+	}
+	err = conn.QueryRow(`SELECT COUNT(*) FROM feed_fetch_log JOIN feeds ON feeds.id = feed_fetch_log.feed_id WHERE feeds.user_id = $1`, user.ID).Scan(&logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs != feeds {
+		t.Errorf("%v fetches in the log, want %v", logs, feeds)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestItemGUID(t *testing.T) {
+	tests := []struct {
+		name string
+		item RSSItem
+		want string
+	}{
+		{
+			name: "guid",
+			item: RSSItem{GUID: " urn:uuid:1 ", Link: "https://x.com/1"},
+			want: "urn:uuid:1",
+		},
+		{
+			name: "no guid, so the link",
+			item: RSSItem{Link: " https://x.com/1 ", Title: "Hi", Description: "anything"},
+			want: "https://x.com/1",
+		},
+		{
+			name: "no guid or link, so a hash of the title",
+			item: RSSItem{Title: "Hi"},
+			want: "sha256:3639efcd08abb273b1619e82e78c29a7df02c1051b1820e99fc395dcaa3326b8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := itemGUID(tt.item); got != tt.want {
+				t.Errorf("itemGUID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Editing a post changes its description (and often its title too), but it has to stay the same post
+func TestItemGUIDStableAcrossEdits(t *testing.T) {
+	before := RSSItem{Title: "Hi", Link: "https://x.com/1", Description: "first draft"}
+	after := RSSItem{Title: "Hi", Link: "https://x.com/1", Description: "fixed a typo"}
+	if itemGUID(before) != itemGUID(after) {
+		t.Errorf("itemGUID() changed when the description was edited: %v != %v", itemGUID(before), itemGUID(after))
+	}
+
+	noLinkBefore := RSSItem{Title: "Hi", Description: "first draft"}
+	noLinkAfter := RSSItem{Title: "Hi", Description: "fixed a typo"}
+	if itemGUID(noLinkBefore) != itemGUID(noLinkAfter) {
+		t.Errorf("itemGUID() of an item with no link changed when the description was edited")
+	}
+}
@@ -0,0 +1,33 @@
+-- Saves the author if we've never seen the name before (ignoring case). If we have, nothing gets written
+-- Then GetAuthorByName gets its id. That's 2 queries, but a DO UPDATE would write a new copy of the row every time
+
+-- name: CreateAuthor :exec
+INSERT INTO authors(id, created_at, name)
+VALUES ($1,$2,$3)
+ON CONFLICT (lower(name)) DO NOTHING;
+
+-- name: GetAuthorByName :one
+SELECT * FROM authors
+WHERE lower(name) = lower(sqlc.arg(name));
+
+-- Links a post to an author. If they're already linked, nothing happens
+
+-- name: AddPostAuthor :exec
+INSERT INTO post_authors(post_id, author_id)
+VALUES ($1,$2)
+ON CONFLICT DO NOTHING;
+
+-- If the feed took an author off a post, we take it off too
+-- author_ids is every author the post has now
+
+-- name: DeleteStalePostAuthors :exec
+DELETE FROM post_authors
+WHERE post_id = sqlc.arg(post_id) AND NOT (author_id = ANY(sqlc.arg(author_ids)::UUID[]));
+
+-- Gets the authors of a page of posts in one go, instead of a query per post
+
+-- name: GetAuthorsForPosts :many
+SELECT post_authors.post_id, authors.name FROM post_authors
+JOIN authors ON authors.id = post_authors.author_id
+WHERE post_authors.post_id = ANY(sqlc.arg(post_ids)::UUID[])
+ORDER BY authors.name;
@@ -0,0 +1,33 @@
+-- Saves the category if we've never seen the name before (ignoring case). If we have, nothing gets written
+-- Then GetCategoryByName gets its id. That's 2 queries, but a DO UPDATE would write a new copy of the row every time
+
+-- name: CreateCategory :exec
+INSERT INTO categories(id, created_at, name)
+VALUES ($1,$2,$3)
+ON CONFLICT (lower(name)) DO NOTHING;
+
+-- name: GetCategoryByName :one
+SELECT * FROM categories
+WHERE lower(name) = lower(sqlc.arg(name));
+
+-- Links a post to a category. If they're already linked, nothing happens
+
+-- name: AddPostCategory :exec
+INSERT INTO post_categories(post_id, category_id)
+VALUES ($1,$2)
+ON CONFLICT DO NOTHING;
+
+-- If the feed took a category off a post, we take it off too
+-- category_ids is every category the post has now
+
+-- name: DeleteStalePostCategories :exec
+DELETE FROM post_categories
+WHERE post_id = sqlc.arg(post_id) AND NOT (category_id = ANY(sqlc.arg(category_ids)::UUID[]));
+
+-- Gets the categories of a page of posts in one go, instead of a query per post
+
+-- name: GetCategoriesForPosts :many
+SELECT post_categories.post_id, categories.name FROM post_categories
+JOIN categories ON categories.id = post_categories.category_id
+WHERE post_categories.post_id = ANY(sqlc.arg(post_ids)::UUID[])
+ORDER BY categories.name;
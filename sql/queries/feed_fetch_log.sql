@@ -0,0 +1,44 @@
+-- Every time the scraper tries to fetch a feed, we save how it went
+
+-- name: CreateFeedFetchLog :one
+INSERT INTO feed_fetch_log(id,
+    created_at,
+    feed_id,
+    status_code,
+    duration_ms,
+    bytes,
+    item_count,
+    new_post_count,
+    error,
+    moved_from
+)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+RETURNING *;
+
+-- Gets the most recent fetch attempts of a feed, newest first
+
+-- name: GetFeedFetchLogs :many
+SELECT * FROM feed_fetch_log
+WHERE feed_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- When 2 feeds get merged, the history of the one that goes away moves to the one that stays
+
+-- name: MoveFeedFetchLogs :exec
+UPDATE feed_fetch_log
+SET feed_id = sqlc.arg(to_feed_id)
+WHERE feed_id = sqlc.arg(from_feed_id);
+
+-- Without this, every feed gets a row per fetch forever, and the table just keeps growing
+-- So after each fetch, we only keep the newest rows of the feed, and delete the rest
+
+-- name: PruneFeedFetchLogs :exec
+DELETE FROM feed_fetch_log
+WHERE feed_fetch_log.feed_id = sqlc.arg(feed_id)
+AND feed_fetch_log.id NOT IN (
+    SELECT newest.id FROM feed_fetch_log newest
+    WHERE newest.feed_id = sqlc.arg(feed_id)
+    ORDER BY newest.created_at DESC
+    LIMIT sqlc.arg(keep)
+);
@@ -15,4 +15,15 @@ SELECT * FROM feed_follows WHERE user_id=$1;
 -- But this will prevent someone other than the user from deleting the feed follow of that user
 
 -- name: DeleteFeedFollow :exec
-DELETE FROM feed_follows WHERE id=$1 AND user_id=$2;
\ No newline at end of file
+DELETE FROM feed_follows WHERE id=$1 AND user_id=$2;
+
+-- When 2 feeds get merged, everyone following the one that goes away follows the one that stays instead
+-- Users already following both are skipped, otherwise they'd be following the same feed twice
+-- Their old follow gets deleted along with the old feed
+
+-- name: MoveFeedFollows :exec
+UPDATE feed_follows
+SET feed_id = sqlc.arg(to_feed_id),
+update_at = NOW()
+WHERE feed_follows.feed_id = sqlc.arg(from_feed_id)
+AND feed_follows.user_id NOT IN (SELECT existing.user_id FROM feed_follows existing WHERE existing.feed_id = sqlc.arg(to_feed_id));
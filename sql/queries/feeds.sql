@@ -1,8 +1,8 @@
 -- We gonna create a query to create a new feed, and to get a new feed
 
 -- name: CreateFeed :one
-INSERT INTO feeds(id,created_at,update_at,name,url,user_id)
-VALUES ($1,$2,$3,$4,$5,$6)
+INSERT INTO feeds(id,created_at,update_at,name,url,user_id,normalized_url)
+VALUES ($1,$2,$3,$4,$5,$6,$7)
 RETURNING *;
 
 -- This query is to get all the feeds from our db
@@ -12,22 +12,127 @@ RETURNING *;
 SELECT * FROM feeds;
 
 
--- This function will go get the feed, that next needs to be fetched
+-- This function will go get the feeds that next need to be fetched, and lease them to the scraper that asked, all in one go
 -- First, we wanna find feeds that have never been fectched, and then ordering them, by most recently fetched/ most unrecently fetched, idk how dates work in sql
--- We are also asking the user how many feeds they want
+-- We are also asking the scraper how many feeds it wants
+-- Disabled feeds, feeds that are backing off after failing (next_fetch_at in the future), and feeds another scraper
+-- is working on (lease_expires_at in the future) are skipped
+-- FOR UPDATE locks the rows we pick till the UPDATE is done, and SKIP LOCKED makes other scrapers running this
+-- at the same time skip those rows instead of waiting for them. So 2 scrapers can never claim the same feed
+-- Claiming a feed also marks it as fetched, which is what MarkFeedAsFetched used to do
 
--- name: GetNextFeedsToFetch :many
+-- name: ClaimNextFeedsToFetch :many
+UPDATE feeds
+SET last_fetched_at = NOW(),
+update_at = NOW(),
+lease_expires_at = NOW() + make_interval(secs => sqlc.arg(lease_seconds)::INT)
+WHERE id IN (
+    SELECT id FROM feeds
+    WHERE disabled_at IS NULL
+    AND (next_fetch_at IS NULL OR next_fetch_at <= NOW())
+    AND (lease_expires_at IS NULL OR lease_expires_at <= NOW())
+    ORDER BY last_fetched_at ASC NULLS FIRST
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- If a scraper claimed a feed but didn't get to fetch it (like when it's shutting down), it lets go of the lease
+-- so another scraper can pick it up straight away, instead of waiting for the lease to run out
+
+-- name: ReleaseFeedLease :exec
+UPDATE feeds
+SET lease_expires_at = NULL
+WHERE id = $1;
+
+-- After a successful fetch, we save the ETag and Last-Modified headers the server sent
+-- so we can send them back on the next fetch
+
+-- name: UpdateFeedValidators :exec
+UPDATE feeds
+SET etag = $2,
+last_modified = $3
+WHERE id = $1;
+
+
+-- name: GetFeedByID :one
+SELECT * FROM feeds WHERE id = $1;
+
+-- Looks for a feed whose normalized url is any of these, so we can tell if a feed is already in the db before adding it again
+-- There's only ever one feed per normalized url, but the http and https versions are 2 different urls,
+-- so if 2 people added them at the same time there could be 2. Then the oldest one wins
+
+-- name: GetFeedByNormalizedURLs :one
 SELECT * FROM feeds
-ORDER BY last_fetched_at ASC NULLS FIRST
-LIMIT $1;
+WHERE normalized_url = ANY(sqlc.arg(normalized_urls)::TEXT[])
+ORDER BY created_at ASC
+LIMIT 1;
 
--- This is the one we call after we fetch the feed,to update it,and return the updated feed
--- The updated_at and created_at fields are mostly for auditing purposes.
--- it's pretty standard practice to set these on every sql record, to see when they were created nd updated
+-- A fetch worked, so the feed is healthy again
+-- next_fetch_at is worked out by the scraper from how often the feed posts
+-- The schedule hints are saved too, so we still have them when the next fetch is a 304
+-- The scraper is done with the feed, so it lets go of the lease
 
--- name: MarkFeedAsFetched :one
+-- name: MarkFeedFetchSucceeded :exec
 UPDATE feeds
-SET last_fetched_at = NOW(),
+SET last_error = NULL,
+consecutive_failures = 0,
+next_fetch_at = $2,
+hint_interval_seconds = $3,
+skip_hours = $4,
+skip_days = $5,
+lease_expires_at = NULL
+WHERE id = $1;
+
+-- A fetch failed, so we save the error and count it
+-- next_fetch_at is the backoff, which the scraper works out
+-- If this failure takes the feed to max_failures failures in a row, the feed gets disabled
+-- The scraper is done with the feed, so it lets go of the lease
+
+-- name: MarkFeedFetchFailed :exec
+UPDATE feeds
+SET last_error = $2,
+consecutive_failures = consecutive_failures + 1,
+next_fetch_at = $3,
+disabled_at = CASE WHEN consecutive_failures + 1 >= sqlc.arg(max_failures)::INT THEN NOW() ELSE disabled_at END,
+lease_expires_at = NULL
+WHERE id = $1;
+
+-- The server told us to slow down (a 429 or 503 with a Retry-After header), so we wait as long as it asked
+-- This isn't the feed being broken, so we save the error but don't count it as a failure
+-- The scraper is done with the feed, so it lets go of the lease
+
+-- name: PostponeFeedFetch :exec
+UPDATE feeds
+SET last_error = $2,
+next_fetch_at = $3,
+lease_expires_at = NULL
+WHERE id = $1;
+
+-- The owner of a feed can turn it back on after it got disabled
+-- We reset the failures too, otherwise the next failure would disable it again straight away
+-- Checking the user_id makes it so only the owner can do this, like we did for DeleteFeedFollow
+
+-- name: EnableFeed :one
+UPDATE feeds
+SET disabled_at = NULL,
+consecutive_failures = 0,
+next_fetch_at = NULL,
 update_at = NOW()
-WHERE id = $1
-RETURNING *;
\ No newline at end of file
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- When a feed moves for good (a 301 or 308 redirect), we save its new url, and its new normalized url
+
+-- name: UpdateFeedURL :exec
+UPDATE feeds
+SET url = $2,
+normalized_url = $3,
+update_at = NOW()
+WHERE id = $1;
+
+-- Once a feed has been merged into another one, we delete it
+-- Its follows, posts and fetch logs that didn't get moved go with it (ON DELETE CASCADE)
+
+-- name: DeleteFeed :exec
+DELETE FROM feeds WHERE id = $1;
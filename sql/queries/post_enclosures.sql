@@ -0,0 +1,33 @@
+-- The scraper saves every enclosure of a post with this, every time it sees the post
+-- A post can't have the same file twice, so if we already have this url we just update it
+-- Only if something about it changed though, otherwise every scrape would write every enclosure again
+
+-- name: UpsertPostEnclosure :exec
+INSERT INTO post_enclosures(id, created_at, post_id, kind, url, mime_type, length, duration_seconds, image_url, episode, season)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+ON CONFLICT (post_id, url) DO UPDATE
+SET kind = EXCLUDED.kind,
+    mime_type = EXCLUDED.mime_type,
+    length = EXCLUDED.length,
+    duration_seconds = EXCLUDED.duration_seconds,
+    image_url = EXCLUDED.image_url,
+    episode = EXCLUDED.episode,
+    season = EXCLUDED.season
+WHERE (post_enclosures.kind, post_enclosures.mime_type, post_enclosures.length, post_enclosures.duration_seconds,
+    post_enclosures.image_url, post_enclosures.episode, post_enclosures.season)
+    IS DISTINCT FROM (EXCLUDED.kind, EXCLUDED.mime_type, EXCLUDED.length, EXCLUDED.duration_seconds,
+    EXCLUDED.image_url, EXCLUDED.episode, EXCLUDED.season);
+
+-- If the feed took an enclosure off a post, we take it off too
+-- urls is everything the post has now, anything else gets deleted
+
+-- name: DeleteStalePostEnclosures :exec
+DELETE FROM post_enclosures
+WHERE post_id = sqlc.arg(post_id) AND NOT (url = ANY(sqlc.arg(urls)::TEXT[]));
+
+-- Gets the enclosures for a page of posts in one go, instead of a query per post
+
+-- name: GetEnclosuresForPosts :many
+SELECT * FROM post_enclosures
+WHERE post_id = ANY(sqlc.arg(post_ids)::UUID[])
+ORDER BY created_at, url;
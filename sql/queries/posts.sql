@@ -1,4 +1,12 @@
--- name: CreatePost :one
+-- UpsertPost creates the post, or updates it if we already have a post with the same guid in this feed
+-- The WHERE on the DO UPDATE makes it so that we only touch the row if something actually changed
+-- If nothing changed, no row is returned at all, so the go code gets an sql.ErrNoRows
+-- If the date is inferred (the feed has no date we could parse), we keep the date we already had, otherwise
+-- every scrape would count as an edit coz the fetch time is always different
+-- Posts saved before we stored content (015_posts_content.sql) have a NULL content. Filling that in isn't the post
+-- being edited, so it doesn't bump the revision or update_at. That's how the scraper tells the two apart
+
+-- name: UpsertPost :one
 INSERT INTO posts(id,
     created_at,
     update_at,
@@ -6,9 +14,39 @@ INSERT INTO posts(id,
     description,
     published_at,
     url,
-    feed_id
+    feed_id,
+    published_at_inferred,
+    guid,
+    content,
+    description_sanitized,
+    content_sanitized,
+    excerpt
 )
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)
+ON CONFLICT (feed_id, guid) DO UPDATE
+SET title = EXCLUDED.title,
+    description = EXCLUDED.description,
+    content = EXCLUDED.content,
+    description_sanitized = EXCLUDED.description_sanitized,
+    content_sanitized = EXCLUDED.content_sanitized,
+    excerpt = EXCLUDED.excerpt,
+    published_at = CASE WHEN EXCLUDED.published_at_inferred THEN posts.published_at ELSE EXCLUDED.published_at END,
+    published_at_inferred = posts.published_at_inferred AND EXCLUDED.published_at_inferred,
+    url = EXCLUDED.url,
+    update_at = CASE WHEN posts.title IS DISTINCT FROM EXCLUDED.title
+        OR posts.description IS DISTINCT FROM EXCLUDED.description
+        OR (posts.content IS NOT NULL AND posts.content IS DISTINCT FROM EXCLUDED.content)
+        OR (NOT EXCLUDED.published_at_inferred AND posts.published_at IS DISTINCT FROM EXCLUDED.published_at)
+        THEN EXCLUDED.update_at ELSE posts.update_at END,
+    revision = CASE WHEN posts.title IS DISTINCT FROM EXCLUDED.title
+        OR posts.description IS DISTINCT FROM EXCLUDED.description
+        OR (posts.content IS NOT NULL AND posts.content IS DISTINCT FROM EXCLUDED.content)
+        OR (NOT EXCLUDED.published_at_inferred AND posts.published_at IS DISTINCT FROM EXCLUDED.published_at)
+        THEN posts.revision + 1 ELSE posts.revision END
+WHERE posts.title IS DISTINCT FROM EXCLUDED.title
+    OR posts.description IS DISTINCT FROM EXCLUDED.description
+    OR posts.content IS DISTINCT FROM EXCLUDED.content
+    OR (NOT EXCLUDED.published_at_inferred AND posts.published_at IS DISTINCT FROM EXCLUDED.published_at)
 RETURNING *;
 
 -- Ok, this query is gonna be a little more complex
@@ -16,10 +54,68 @@ RETURNING *;
 -- To know that, we gotta use a join, to get only the posts, who have feed_ids that the user is following
 -- We also take the user_id as input as we gotta know who we want to get the feeds for
 -- And also we r ordering them as most recent, and limiting how many posts we get per request
+-- author and category are optional filters. When they're NULL they don't filter anything, otherwise
+-- we only get posts that have that author/category (ignoring case)
 
 -- name: GetPostsForUser :many
 SELECT posts.* from posts
 JOIN feed_follows ON posts.feed_id = feed_follows.feed_id
-WHERE feed_follows.user_id = $1
+WHERE feed_follows.user_id = sqlc.arg(user_id)
+AND (sqlc.narg(author)::TEXT IS NULL OR EXISTS (
+    SELECT 1 FROM post_authors
+    JOIN authors ON authors.id = post_authors.author_id
+    WHERE post_authors.post_id = posts.id AND lower(authors.name) = lower(sqlc.narg(author))
+))
+AND (sqlc.narg(category)::TEXT IS NULL OR EXISTS (
+    SELECT 1 FROM post_categories
+    JOIN categories ON categories.id = post_categories.category_id
+    WHERE post_categories.post_id = posts.id AND lower(categories.name) = lower(sqlc.narg(category))
+))
 ORDER BY posts.published_at DESC
-LIMIT $2;
\ No newline at end of file
+LIMIT sqlc.arg('limit');
+
+-- The scraper uses this to work out how often a feed posts, so it knows how often to fetch it
+-- Inferred dates are just when we fetched the post, so they'd mess up the math, hence we skip them
+
+-- name: GetRecentPublishDates :many
+SELECT published_at FROM posts
+WHERE feed_id = $1 AND NOT published_at_inferred
+ORDER BY published_at DESC
+LIMIT $2;
+
+-- When 2 feeds get merged, the posts of the one that goes away move to the one that stays
+-- Posts the other feed already has (same guid) are skipped, and get deleted along with the old feed
+
+-- name: MovePosts :exec
+UPDATE posts
+SET feed_id = sqlc.arg(to_feed_id)
+WHERE posts.feed_id = sqlc.arg(from_feed_id)
+AND posts.guid NOT IN (SELECT existing.guid FROM posts existing WHERE existing.feed_id = sqlc.arg(to_feed_id));
+
+-- When a post hasn't changed, UpsertPost doesn't return it, but the scraper still needs its id to save its enclosures, authors and categories
+
+-- name: GetPostIDByGUID :one
+SELECT id FROM posts
+WHERE feed_id = $1 AND guid = $2;
+
+-- Before posts had guids, 008_posts_guid.sql gave every post we had its url as its guid, and flagged it with legacy_guid
+-- So when we see a post whose guid we don't have, it could still be one of those old rows. If it is, it gets the
+-- post's real guid, so it doesn't get saved a second time
+-- Only the flagged rows are taken over, coz some feeds really do have different posts with the same url,
+-- and a post that got its link as its guid coz it has no guid isn't an old copy of anything
+-- The flag gets cleared either way, so each old row is only ever looked at once
+
+-- name: AdoptLegacyPost :exec
+UPDATE posts
+SET guid = sqlc.arg(guid), legacy_guid = false
+WHERE posts.id = (
+    SELECT legacy.id FROM posts legacy
+    WHERE legacy.feed_id = sqlc.arg(feed_id)
+    AND legacy.url = sqlc.arg(url)
+    AND legacy.legacy_guid
+    LIMIT 1
+)
+AND NOT EXISTS (
+    SELECT 1 FROM posts existing
+    WHERE existing.feed_id = sqlc.arg(feed_id) AND existing.guid = sqlc.arg(guid) AND existing.id <> posts.id
+);
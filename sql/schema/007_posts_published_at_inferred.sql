@@ -0,0 +1,14 @@
+-- When we can't figure out when a post was published, we used to store the zero time (year 0001)
+-- That pushes those posts to the very bottom of GetPostsForUser, as it's ordered by published_at
+-- Now we fallback on the time we fetched the post instead, and this column flags that the date was made up by us
+-- so clients can show it differently if they want
+
+-- We also fix up the posts we already stored with the zero time, by giving them their created_at instead
+
+-- +goose Up
+ALTER TABLE posts ADD COLUMN published_at_inferred BOOLEAN NOT NULL DEFAULT false;
+UPDATE posts SET published_at = created_at, published_at_inferred = true
+WHERE published_at < '1970-01-01';
+
+-- +goose Down
+ALTER TABLE posts DROP COLUMN published_at_inferred;
@@ -0,0 +1,24 @@
+-- Remember the question in 006_posts.sql? Using the url as the thing that makes a post unique wasn't great
+-- Some feeds reuse links, or add tracking params that change every time, and some items don't even have a <link>
+-- RSS gives every item a <guid> (and Atom an <id>) for exactly this, so now a post is identified by (feed_id, guid) instead
+-- Items without a guid get their link as their guid (or a hash of their title if they don't have a link either), that's done in the scraper
+
+-- The posts we already have don't have a guid, so we just use their url, as that's what identified them till now
+-- They're flagged with legacy_guid, and get their real guid the next time the scraper sees them (see AdoptLegacyPost in sql/queries/posts.sql)
+-- The scraper finds them by their url, so there's an index for that. It only has the flagged rows in it, so it stays small
+
+-- +goose Up
+ALTER TABLE posts ADD COLUMN guid TEXT;
+ALTER TABLE posts ADD COLUMN legacy_guid BOOLEAN NOT NULL DEFAULT false;
+UPDATE posts SET guid = url, legacy_guid = true;
+ALTER TABLE posts ALTER COLUMN guid SET NOT NULL;
+ALTER TABLE posts DROP CONSTRAINT posts_url_key;
+ALTER TABLE posts ADD CONSTRAINT posts_feed_id_guid_key UNIQUE(feed_id, guid);
+CREATE INDEX posts_legacy_guid_idx ON posts(feed_id, url) WHERE legacy_guid;
+
+-- +goose Down
+DROP INDEX posts_legacy_guid_idx;
+ALTER TABLE posts DROP CONSTRAINT posts_feed_id_guid_key;
+ALTER TABLE posts ADD CONSTRAINT posts_url_key UNIQUE(url);
+ALTER TABLE posts DROP COLUMN legacy_guid;
+ALTER TABLE posts DROP COLUMN guid;
@@ -0,0 +1,9 @@
+-- This answers the question from 006_posts.sql. When a post gets edited at the source, the scraper now updates our row
+-- instead of ignoring it. revision starts at 1, and goes up by one every time the title/description/date changes
+-- so clients can show an "updated" badge on posts with revision > 1
+
+-- +goose Up
+ALTER TABLE posts ADD COLUMN revision INT NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE posts DROP COLUMN revision;
@@ -0,0 +1,13 @@
+-- Every time the scraper runs, it downloads the whole feed, even if nothing changed since last time
+-- HTTP has a way around that called a conditional GET. The server gives us an ETag and/or a Last-Modified header,
+-- and next time we send them back as If-None-Match/If-Modified-Since. If nothing changed, the server just says 304 Not Modified
+-- with no body. So we store the validators the server last gave us for each feed
+-- They're TEXT and not a timestamp coz we just send them back exactly how we got them
+
+-- +goose Up
+ALTER TABLE feeds ADD COLUMN etag TEXT;
+ALTER TABLE feeds ADD COLUMN last_modified TEXT;
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN last_modified;
+ALTER TABLE feeds DROP COLUMN etag;
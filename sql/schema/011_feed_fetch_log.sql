@@ -0,0 +1,33 @@
+-- When fetching a feed failed, the scraper just logged it, and the feed looked totally fine to anyone using the API
+-- So now every fetch attempt gets a row in feed_fetch_log, with how it went
+-- status_code is NULL when we never got a response (like a timeout or a dns error)
+-- error is NULL when the fetch worked
+
+-- moved_from is only set on the fetch where the feed answered with a permanent redirect (301 or 308),
+-- it's the url the feed had before that fetch, so u can see in the feed's history when and where from it moved
+
+-- We also keep the last error and how many fetches in a row have failed on the feed itself,
+-- so u can tell a feed is broken just from GET /v1/feeds
+
+-- +goose Up
+CREATE TABLE feed_fetch_log(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    feed_id UUID NOT NULL REFERENCES feeds(id) ON DELETE CASCADE,
+    status_code INT,
+    duration_ms INT NOT NULL,
+    bytes BIGINT NOT NULL,
+    item_count INT NOT NULL,
+    new_post_count INT NOT NULL,
+    error TEXT,
+    moved_from TEXT
+);
+CREATE INDEX feed_fetch_log_feed_id_created_at_idx ON feed_fetch_log(feed_id, created_at DESC);
+
+ALTER TABLE feeds ADD COLUMN last_error TEXT;
+ALTER TABLE feeds ADD COLUMN consecutive_failures INT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN consecutive_failures;
+ALTER TABLE feeds DROP COLUMN last_error;
+DROP TABLE feed_fetch_log;
@@ -0,0 +1,12 @@
+-- Feeds that are down (404s, timeouts etc) used to get handed out to the scraper every single time,
+-- taking up spots in the batch that healthy feeds could have used
+-- Now, every time a fetch fails, we push next_fetch_at further out (exponential backoff), and GetNextFeedsToFetch skips
+-- feeds that aren't due yet. After too many failures in a row, the feed gets disabled, and it stays that way till its owner re-enables it
+
+-- +goose Up
+ALTER TABLE feeds ADD COLUMN next_fetch_at TIMESTAMP;
+ALTER TABLE feeds ADD COLUMN disabled_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN disabled_at;
+ALTER TABLE feeds DROP COLUMN next_fetch_at;
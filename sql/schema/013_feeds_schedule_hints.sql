@@ -0,0 +1,15 @@
+-- The scraper works out when to fetch a feed next from <ttl>, <skipHours>, <skipDays> and sy:updatePeriod/sy:updateFrequency
+-- When the feed answers 304 Not Modified there's no feed to read them from, so we save them on the feed every time
+-- we do get the feed, and use the saved ones on a 304
+-- hint_interval_seconds is the longest of <ttl> and the sy: interval, NULL if the feed has neither
+-- skip_hours are 0-23 (GMT) and skip_days are 0-6 (Sunday is 0), like go's time.Weekday
+
+-- +goose Up
+ALTER TABLE feeds ADD COLUMN hint_interval_seconds INT;
+ALTER TABLE feeds ADD COLUMN skip_hours INT[] NOT NULL DEFAULT '{}';
+ALTER TABLE feeds ADD COLUMN skip_days INT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN skip_days;
+ALTER TABLE feeds DROP COLUMN skip_hours;
+ALTER TABLE feeds DROP COLUMN hint_interval_seconds;
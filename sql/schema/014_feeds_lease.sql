@@ -0,0 +1,11 @@
+-- If we run more than one copy of the server, every copy's scraper used to grab the same feeds from GetNextFeedsToFetch
+-- and fetch them at the same time, as picking the feeds and marking them as fetched were 2 separate queries
+-- Now a scraper "leases" the feeds it picks, in the same query that picks them. While the lease hasn't expired,
+-- no other scraper will pick that feed. The lease is let go once the fetch is done, and if a scraper dies halfway,
+-- the lease just runs out and another scraper picks the feed up
+
+-- +goose Up
+ALTER TABLE feeds ADD COLUMN lease_expires_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE feeds DROP COLUMN lease_expires_at;
@@ -0,0 +1,11 @@
+-- description is usually just a summary of the post. A lot of feeds put the whole article in <content:encoded>
+-- (or <content> in Atom, content_html in JSON Feed), and we used to just throw that away
+-- content is NULL when the feed only gives us a summary
+-- Posts we already have get their content filled in the next time their feed is scraped
+-- That's not an edit, so it doesn't bump their revision (see UpsertPost)
+
+-- +goose Up
+ALTER TABLE posts ADD COLUMN content TEXT;
+
+-- +goose Down
+ALTER TABLE posts DROP COLUMN content;
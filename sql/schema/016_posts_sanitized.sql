@@ -0,0 +1,15 @@
+-- description and content are html straight from the feed, so they can have <script>s, onclick="..."s and tracking pixels in them
+-- The scraper now cleans them up before saving them (see internal/sanitize), and the API only ever returns the clean versions
+-- We keep the raw html too, so we can tell if the post changed, and re-clean it if we ever change the rules
+-- excerpt is a short plain text version, for when a client just wants to show a preview
+-- These are NULL for posts saved before this, the API cleans those up as it returns them
+
+-- +goose Up
+ALTER TABLE posts ADD COLUMN description_sanitized TEXT;
+ALTER TABLE posts ADD COLUMN content_sanitized TEXT;
+ALTER TABLE posts ADD COLUMN excerpt TEXT;
+
+-- +goose Down
+ALTER TABLE posts DROP COLUMN excerpt;
+ALTER TABLE posts DROP COLUMN content_sanitized;
+ALTER TABLE posts DROP COLUMN description_sanitized;
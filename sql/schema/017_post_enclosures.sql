@@ -0,0 +1,24 @@
+-- Podcasts and video feeds attach the actual audio/video file to each post as an <enclosure> or <media:content>
+-- We used to throw those away, so a podcast episode was just a title and a link
+-- A post can have more than one (like the episode and its thumbnail), so they get their own table
+-- kind is where it came from: enclosure, media_content or media_thumbnail
+-- duration_seconds, image_url, episode and season come from the itunes: tags, and are NULL if the feed didn't have them
+
+-- +goose Up
+CREATE TABLE post_enclosures(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    url TEXT NOT NULL,
+    mime_type TEXT,
+    length BIGINT,
+    duration_seconds INT,
+    image_url TEXT,
+    episode INT,
+    season INT,
+    UNIQUE(post_id, url)
+);
+
+-- +goose Down
+DROP TABLE post_enclosures;
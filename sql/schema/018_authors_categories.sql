@@ -0,0 +1,40 @@
+-- We used to drop the authors and categories of posts, so there was no way to filter by them
+-- The same author or category shows up on loads of posts (and across feeds), so they get their own tables,
+-- and post_authors / post_categories link them to posts
+-- Names are matched ignoring case, so "Go" and "go" are the same category. Whichever we saw first is how it's written
+
+-- +goose Up
+CREATE TABLE authors(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL
+);
+CREATE UNIQUE INDEX authors_name_idx ON authors(lower(name));
+
+CREATE TABLE post_authors(
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    author_id UUID NOT NULL REFERENCES authors(id) ON DELETE CASCADE,
+    PRIMARY KEY(post_id, author_id)
+);
+-- The primary key covers looking up by post, this is for filtering posts by author
+CREATE INDEX post_authors_author_id_idx ON post_authors(author_id);
+
+CREATE TABLE categories(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL
+);
+CREATE UNIQUE INDEX categories_name_idx ON categories(lower(name));
+
+CREATE TABLE post_categories(
+    post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    PRIMARY KEY(post_id, category_id)
+);
+CREATE INDEX post_categories_category_id_idx ON post_categories(category_id);
+
+-- +goose Down
+DROP TABLE post_categories;
+DROP TABLE categories;
+DROP TABLE post_authors;
+DROP TABLE authors;
@@ -0,0 +1,112 @@
+-- url is the url we actually fetch, written exactly how it was submitted (or where discovery and redirects took us)
+-- Some sites care about exactly how the url is written, like WordPress redirecting /feed to /feed/, so we don't touch it
+-- normalized_url (see internal/feedurl) is only for spotting the same feed added twice
+
+-- The feeds we already have need a normalized_url too, so we fill it in here
+-- This does what feedurl.Normalize does: lowercase scheme and host, no default port, no trailing slash, no #fragment,
+-- no tracking parameters, and the rest of the parameters sorted. The only thing it doesn't do is re-escape the query,
+-- which only matters for the odd url with weirdly escaped parameters
+
+-- +goose Up
+ALTER TABLE feeds ADD COLUMN normalized_url TEXT;
+
+-- +goose StatementBegin
+CREATE FUNCTION normalize_feed_url(raw TEXT) RETURNS TEXT AS $$
+DECLARE
+    parts TEXT[];
+    authority TEXT[];
+    scheme TEXT;
+    host TEXT;
+    path TEXT;
+    query TEXT;
+BEGIN
+    parts := regexp_match(btrim(raw), '^([A-Za-z][A-Za-z0-9+.-]*)://([^/?#]*)([^?#]*)(\?[^#]*)?');
+    IF parts IS NULL THEN
+        RETURN btrim(raw);
+    END IF;
+
+    scheme := lower(parts[1]);
+    -- The user:password@ bit (if there is one) keeps its case, only the host gets lowercased
+    authority := regexp_match(parts[2], '^(.*@)?(.*)$');
+    host := lower(authority[2]);
+    IF scheme = 'http' THEN
+        host := regexp_replace(host, ':80$', '');
+    ELSIF scheme = 'https' THEN
+        host := regexp_replace(host, ':443$', '');
+    END IF;
+
+    path := rtrim(parts[3], '/');
+    IF path = '' THEN
+        path := '/';
+    END IF;
+
+    SELECT string_agg(param, '&' ORDER BY split_part(param, '=', 1), param) INTO query
+    FROM unnest(string_to_array(substr(coalesce(parts[4], ''), 2), '&')) AS param
+    WHERE param <> ''
+    AND lower(split_part(param, '=', 1)) NOT LIKE 'utm\_%'
+    AND lower(split_part(param, '=', 1)) NOT IN ('fbclid', 'gclid', 'dclid', 'msclkid', 'mc_cid', 'mc_eid', 'igshid', '_ga');
+
+    RETURN scheme || '://' || coalesce(authority[1], '') || host || path || CASE WHEN coalesce(query, '') <> '' THEN '?' || query ELSE '' END;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+UPDATE feeds SET normalized_url = normalize_feed_url(url);
+DROP FUNCTION normalize_feed_url(TEXT);
+
+ALTER TABLE feeds ALTER COLUMN normalized_url SET NOT NULL;
+
+-- The feeds we already have can be duplicates of each other, that's the whole reason for this migration
+-- So we merge them the same way moveFeed does: the oldest one stays, and the follows, posts and fetch history
+-- of the rest move over to it. http:// and https:// count as the same feed (see feedurl.Variants), so we leave the scheme out
+CREATE TEMPORARY TABLE feed_duplicates AS
+SELECT feeds.id AS from_feed_id,
+    first_value(feeds.id) OVER (PARTITION BY regexp_replace(feeds.normalized_url, '^https?://', '') ORDER BY feeds.created_at, feeds.id) AS to_feed_id
+FROM feeds;
+DELETE FROM feed_duplicates WHERE from_feed_id = to_feed_id;
+
+-- A user could be following a few of the duplicates, and can only follow the feed that stays once
+-- So only their oldest follow moves, and only if they don't already follow the feed that stays. The rest go with the duplicates
+UPDATE feed_follows
+SET feed_id = dup.to_feed_id,
+update_at = NOW()
+FROM feed_duplicates dup
+WHERE feed_follows.feed_id = dup.from_feed_id
+AND feed_follows.id = (
+    SELECT oldest.id FROM feed_follows oldest
+    JOIN feed_duplicates oldest_dup ON oldest.feed_id = oldest_dup.from_feed_id
+    WHERE oldest_dup.to_feed_id = dup.to_feed_id AND oldest.user_id = feed_follows.user_id
+    ORDER BY oldest.created_at, oldest.id
+    LIMIT 1
+)
+AND NOT EXISTS (SELECT 1 FROM feed_follows existing WHERE existing.feed_id = dup.to_feed_id AND existing.user_id = feed_follows.user_id);
+
+-- Same thing for posts, a guid can only be in the feed that stays once
+UPDATE posts
+SET feed_id = dup.to_feed_id
+FROM feed_duplicates dup
+WHERE posts.feed_id = dup.from_feed_id
+AND posts.id = (
+    SELECT oldest.id FROM posts oldest
+    JOIN feed_duplicates oldest_dup ON oldest.feed_id = oldest_dup.from_feed_id
+    WHERE oldest_dup.to_feed_id = dup.to_feed_id AND oldest.guid = posts.guid
+    ORDER BY oldest.created_at, oldest.id
+    LIMIT 1
+)
+AND NOT EXISTS (SELECT 1 FROM posts existing WHERE existing.feed_id = dup.to_feed_id AND existing.guid = posts.guid);
+
+UPDATE feed_fetch_log
+SET feed_id = dup.to_feed_id
+FROM feed_duplicates dup
+WHERE feed_fetch_log.feed_id = dup.from_feed_id;
+
+-- Whatever didn't move gets deleted along with the duplicates
+DELETE FROM feeds WHERE feeds.id IN (SELECT from_feed_id FROM feed_duplicates);
+DROP TABLE feed_duplicates;
+
+-- UNIQUE, so 2 people adding the same feed at the same time can't both save it (see handlerCreateFeed)
+CREATE UNIQUE INDEX feeds_normalized_url_idx ON feeds(normalized_url);
+
+-- +goose Down
+DROP INDEX feeds_normalized_url_idx;
+ALTER TABLE feeds DROP COLUMN normalized_url;